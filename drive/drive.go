@@ -9,7 +9,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -18,18 +20,18 @@ import (
 	"google.golang.org/api/option"
 )
 
-type Auth struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
-
 type AuthResponse struct {
-	Token string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
 }
 
+// errNotAuthenticated asks the poller to request a new access token.
+var errNotAuthenticated = fmt.Errorf("not authenticated")
+
 type LogEntry struct {
 	Email     string `json:"email"`
 	Timestamp string `json:"timestamp"`
+	Event     string `json:"event"`
 	Success   bool   `json:"success"`
 	IP        string `json:"ip"`
 	UserAgent string `json:"user_agent"`
@@ -37,6 +39,8 @@ type LogEntry struct {
 
 type Config struct {
 	APIBaseURL      string
+	ClientID        string
+	ClientSecret    string
 	PollingInterval time.Duration
 	CredentialsFile string
 	FolderID        string
@@ -77,24 +81,22 @@ func (s *ServiceClient) Initialize() error {
 	return nil
 }
 
+// Login obtains an access token with the client credentials grant. The
+// exporter is registered as a confidential client allowed the logs:read scope.
 func (s *ServiceClient) Login() error {
-	authData := Auth{
-		Email:    os.Getenv("EMAIL"),
-		Password: os.Getenv("PASSWORD"),
-	}
-
-	jsonData, err := json.Marshal(authData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal auth data: %v", err)
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"logs:read"},
 	}
 
-	url := fmt.Sprintf("%s/api/login", s.config.APIBaseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	tokenURL := fmt.Sprintf("%s/token", s.config.APIBaseURL)
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.config.ClientID, s.config.ClientSecret)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -112,23 +114,23 @@ func (s *ServiceClient) Login() error {
 		return fmt.Errorf("failed to decode auth response: %v", err)
 	}
 
-	s.jwtToken = authResp.Token
+	s.jwtToken = authResp.AccessToken
 	log.Println("Login successful")
 	return nil
 }
 
 func (s *ServiceClient) FetchLogs() ([]LogEntry, error) {
 	if s.jwtToken == "" {
-		return nil, fmt.Errorf("not authenticated")
+		return nil, errNotAuthenticated
 	}
 
-	url := fmt.Sprintf("%s/api/protected/logs", s.config.APIBaseURL)
-	req, err := http.NewRequest("GET", url, nil)
+	logsURL := fmt.Sprintf("%s/api/protected/logs", s.config.APIBaseURL)
+	req, err := http.NewRequest("GET", logsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("Authorization", s.jwtToken)
+	req.Header.Set("Authorization", "Bearer "+s.jwtToken)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -136,6 +138,12 @@ func (s *ServiceClient) FetchLogs() ([]LogEntry, error) {
 	}
 	defer resp.Body.Close()
 
+	// The token expired or was revoked.
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.jwtToken = ""
+		return nil, errNotAuthenticated
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("fetching logs failed with status %d: %s", resp.StatusCode, string(body))
@@ -154,7 +162,7 @@ func (s *ServiceClient) ConvertLogsToCSV(logs []LogEntry) ([]byte, error) {
 	var buf bytes.Buffer
 	csvWriter := csv.NewWriter(&buf)
 
-	header := []string{"Email", "Timestamp", "Success", "IP", "UserAgent"}
+	header := []string{"Email", "Timestamp", "Event", "Success", "IP", "UserAgent"}
 	if err := csvWriter.Write(header); err != nil {
 		return nil, fmt.Errorf("failed to write CSV header: %v", err)
 	}
@@ -165,9 +173,15 @@ func (s *ServiceClient) ConvertLogsToCSV(logs []LogEntry) ([]byte, error) {
 			success = "true"
 		}
 
+		event := log.Event
+		if event == "" {
+			event = "login"
+		}
+
 		row := []string{
 			log.Email,
 			log.Timestamp,
+			event,
 			success,
 			log.IP,
 			log.UserAgent,
@@ -221,7 +235,7 @@ func (s *ServiceClient) StartPolling() {
 		logs, err := s.FetchLogs()
 		if err != nil {
 			log.Printf("Error fetching logs: %v", err)
-			if err.Error() == "not authenticated" {
+			if err == errNotAuthenticated {
 				if err := s.Login(); err != nil {
 					log.Printf("Error re-authenticating: %v", err)
 				}
@@ -253,6 +267,8 @@ func main() {
 
 	config := Config{
 		APIBaseURL:      "http://localhost:7777",
+		ClientID:        os.Getenv("CLIENT_ID"),
+		ClientSecret:    os.Getenv("CLIENT_SECRET"),
 		PollingInterval: 180 * time.Second,
 		CredentialsFile: "credentials.json",
 		FolderID:        os.Getenv("FOLDER_ID"),
@@ -1,21 +1,89 @@
 package config
 
 import (
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Email verification policies.
+const (
+	EmailVerificationOff      = "off"
+	EmailVerificationRestrict = "restrict"
+	EmailVerificationBlock    = "block"
+)
+
+// RateLimit allows Limit requests per Period. A zero Limit disables it.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
 type Config struct {
-	JWTSecret             string
-	JWTExpiration         time.Duration
-	DatabaseURL           string
-	RedisURL              string
-	ServerPort            string
-	GoogleCredentialsPath string
-	GoogleDriveLogFolder  string
-	LogExportInterval     time.Duration
+	JWTSecret               string
+	JWTSigningAlg           string
+	JWTPrivateKeyPath       string
+	JWTKeyID                string
+	EncryptionKey           string
+	KeyReloadInterval       time.Duration
+	JWTExpiration           time.Duration
+	Issuer                  string
+	SessionDuration         time.Duration
+	CORSOrigins             []string
+	TrustedProxies          []string
+	EmailVerification       string
+	MailTransport           string
+	MailFrom                string
+	SMTPHost                string
+	SMTPPort                int
+	SMTPUsername            string
+	SMTPPassword            string
+	SMTPImplicitTLS         bool
+	SMTPRequireTLS          bool
+	MaildirPath             string
+	PasswordMinLength       int
+	PasswordMaxLength       int
+	PasswordClasses         []string
+	PasswordBannedFile      string
+	PasswordBreachDataset   string
+	PasswordBreachAction    string
+	PasswordHash            string
+	BcryptCost              int
+	Argon2Memory            uint32
+	Argon2Iterations        uint32
+	Argon2Parallelism       uint8
+	Argon2MemoryLimit       uint32
+	TOTPIssuer              string
+	WebAuthnRPID            string
+	WebAuthnRPName          string
+	WebAuthnOrigins         []string
+	LoginFailureWindow      time.Duration
+	LoginBackoffAfter       int
+	LoginLockoutThreshold   int
+	LoginIPLockoutThreshold int
+	LoginLockoutDuration    time.Duration
+	StepUpMaxAge            time.Duration
+	StepUpACR               string
+	RateLimitRegisterIP     RateLimit
+	RateLimitRegisterEmail  RateLimit
+	RateLimitLoginIP        RateLimit
+	RateLimitLoginEmail     RateLimit
+	RateLimitRefreshIP      RateLimit
+	RateLimitVerifyIP       RateLimit
+	RateLimitTokenIP        RateLimit
+	RateLimitTokenClient    RateLimit
+	RateLimitMailIP         RateLimit
+	RateLimitMailEmail      RateLimit
+	DatabaseURL             string
+	RedisURL                string
+	ServerPort              string
+	GoogleCredentialsPath   string
+	GoogleDriveLogFolder    string
+	LogExportInterval       time.Duration
 }
 
 func LoadConfig() (Config, error) {
@@ -30,14 +98,354 @@ func LoadConfig() (Config, error) {
 		}
 	}
 
+	keyReloadInterval := time.Minute
+	if val := os.Getenv("KEY_RELOAD_INTERVAL"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			keyReloadInterval = duration
+		}
+	}
+
+	issuer := os.Getenv("ISSUER")
+	if issuer == "" {
+		port := os.Getenv("SERVER_PORT")
+		if port == "" {
+			port = "8080"
+		}
+		issuer = "http://localhost:" + port
+	}
+
+	sessionDuration := time.Hour * 24
+	if val := os.Getenv("SESSION_DURATION"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			sessionDuration = duration
+		}
+	}
+
+	var corsOrigins []string
+	if val := os.Getenv("CORS_ORIGINS"); val != "" {
+		for _, origin := range strings.Split(val, ",") {
+			corsOrigins = append(corsOrigins, strings.TrimSpace(origin))
+		}
+	}
+
+	// X-Forwarded-For and X-Real-IP are only believed on connections from
+	// TRUSTED_PROXIES, a comma separated list of addresses or CIDR ranges.
+	var trustedProxies []string
+	if val := os.Getenv("TRUSTED_PROXIES"); val != "" {
+		for _, proxy := range strings.Split(val, ",") {
+			trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+
+	// "block" rejects sign in until the email is verified, "restrict" only
+	// grants basic scopes and "off" disables the check.
+	emailVerification := os.Getenv("EMAIL_VERIFICATION")
+	if emailVerification == "" {
+		emailVerification = EmailVerificationRestrict
+	}
+
+	// "smtp" sends mail, "maildir" drops it into MAILDIR_PATH for
+	// development and "log" only logs it.
+	mailTransport := os.Getenv("MAIL_TRANSPORT")
+	if mailTransport == "" {
+		mailTransport = "log"
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "SSO <no-reply@localhost>"
+	}
+
+	smtpPort := 587
+	if val := os.Getenv("SMTP_PORT"); val != "" {
+		if port, err := strconv.Atoi(val); err == nil {
+			smtpPort = port
+		}
+	}
+
+	// SMTP_REQUIRE_TLS=false lets mail go out unencrypted to servers that
+	// do not offer STARTTLS.
+	smtpRequireTLS := true
+	if val := os.Getenv("SMTP_REQUIRE_TLS"); val != "" {
+		if require, err := strconv.ParseBool(val); err == nil {
+			smtpRequireTLS = require
+		}
+	}
+
+	maildirPath := os.Getenv("MAILDIR_PATH")
+	if maildirPath == "" {
+		maildirPath = "maildir"
+	}
+
+	passwordMinLength := 8
+	if val := os.Getenv("PASSWORD_MIN_LENGTH"); val != "" {
+		if length, err := strconv.Atoi(val); err == nil {
+			passwordMinLength = length
+		}
+	}
+
+	// bcrypt only uses the first 72 bytes, which is also the upper bound.
+	passwordMaxLength := 72
+	if val := os.Getenv("PASSWORD_MAX_LENGTH"); val != "" {
+		if length, err := strconv.Atoi(val); err == nil {
+			passwordMaxLength = length
+		}
+	}
+
+	// Comma separated list of lower, upper, digit and symbol.
+	var passwordClasses []string
+	if val := os.Getenv("PASSWORD_CHARACTER_CLASSES"); val != "" {
+		for _, class := range strings.Split(val, ",") {
+			passwordClasses = append(passwordClasses, strings.TrimSpace(class))
+		}
+	}
+
+	// "reject", "warn" or "flag" passwords found in PASSWORD_BREACH_DATASET.
+	passwordBreachAction := os.Getenv("PASSWORD_BREACH_ACTION")
+	if passwordBreachAction == "" {
+		passwordBreachAction = "reject"
+	}
+
+	// New passwords are hashed with PASSWORD_HASH, "argon2id" or "bcrypt".
+	// Hashes of the other algorithm or with other parameters are upgraded
+	// when the user signs in.
+	passwordHash := os.Getenv("PASSWORD_HASH")
+	if passwordHash == "" {
+		passwordHash = "argon2id"
+	}
+
+	bcryptCost := 10
+	if val := os.Getenv("BCRYPT_COST"); val != "" {
+		if cost, err := strconv.Atoi(val); err == nil {
+			bcryptCost = cost
+		}
+	}
+
+	// Memory is in KiB.
+	argon2Memory := uint32(64 * 1024)
+	if val := os.Getenv("ARGON2_MEMORY"); val != "" {
+		if memory, err := strconv.ParseUint(val, 10, 32); err == nil {
+			argon2Memory = uint32(memory)
+		}
+	}
+
+	argon2Iterations := uint32(3)
+	if val := os.Getenv("ARGON2_ITERATIONS"); val != "" {
+		if iterations, err := strconv.ParseUint(val, 10, 32); err == nil {
+			argon2Iterations = uint32(iterations)
+		}
+	}
+
+	argon2Parallelism := uint8(2)
+	if val := os.Getenv("ARGON2_PARALLELISM"); val != "" {
+		if parallelism, err := strconv.ParseUint(val, 10, 8); err == nil {
+			argon2Parallelism = uint8(parallelism)
+		}
+	}
+
+	// Hashes computed at once may use up to ARGON2_MEMORY_LIMIT KiB in
+	// total, further logins wait. At least one is always computed.
+	argon2MemoryLimit := uint32(1024 * 1024)
+	if val := os.Getenv("ARGON2_MEMORY_LIMIT"); val != "" {
+		if limit, err := strconv.ParseUint(val, 10, 32); err == nil {
+			argon2MemoryLimit = uint32(limit)
+		}
+	}
+
+	// Authenticator apps list the account under TOTP_ISSUER.
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "SSO"
+	}
+
+	// Passkeys are bound to WEBAUTHN_RP_ID, a domain, and only accepted from
+	// WEBAUTHN_ORIGINS, a comma separated list. Both default to the issuer,
+	// pages on other origins such as index.html have to be listed.
+	webAuthnRPID := os.Getenv("WEBAUTHN_RP_ID")
+	webAuthnOrigins := []string{}
+	if issuerURL, err := url.Parse(issuer); err == nil {
+		if webAuthnRPID == "" {
+			webAuthnRPID = issuerURL.Hostname()
+		}
+		webAuthnOrigins = append(webAuthnOrigins, issuerURL.Scheme+"://"+issuerURL.Host)
+	}
+	if val := os.Getenv("WEBAUTHN_ORIGINS"); val != "" {
+		webAuthnOrigins = nil
+		for _, origin := range strings.Split(val, ",") {
+			webAuthnOrigins = append(webAuthnOrigins, strings.TrimSpace(origin))
+		}
+	}
+
+	webAuthnRPName := os.Getenv("WEBAUTHN_RP_NAME")
+	if webAuthnRPName == "" {
+		webAuthnRPName = totpIssuer
+	}
+
+	// Failed logins within LOGIN_FAILURE_WINDOW are counted per account and
+	// per IP address. After LOGIN_BACKOFF_AFTER of them each attempt waits
+	// longer, at the thresholds logins are refused for LOGIN_LOCKOUT_DURATION.
+	// Zero disables the backoff or the lockout.
+	loginFailureWindow := 15 * time.Minute
+	if val := os.Getenv("LOGIN_FAILURE_WINDOW"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			loginFailureWindow = duration
+		}
+	}
+
+	loginBackoffAfter := 3
+	if val := os.Getenv("LOGIN_BACKOFF_AFTER"); val != "" {
+		if count, err := strconv.Atoi(val); err == nil {
+			loginBackoffAfter = count
+		}
+	}
+
+	loginLockoutThreshold := 10
+	if val := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); val != "" {
+		if count, err := strconv.Atoi(val); err == nil {
+			loginLockoutThreshold = count
+		}
+	}
+
+	loginIPLockoutThreshold := 100
+	if val := os.Getenv("LOGIN_IP_LOCKOUT_THRESHOLD"); val != "" {
+		if count, err := strconv.Atoi(val); err == nil {
+			loginIPLockoutThreshold = count
+		}
+	}
+
+	loginLockoutDuration := 15 * time.Minute
+	if val := os.Getenv("LOGIN_LOCKOUT_DURATION"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			loginLockoutDuration = duration
+		}
+	}
+
+	// Changing the password, managing second factors, deleting users and
+	// reading everyone's logs need a sign in within STEP_UP_MAX_AGE and, if
+	// set, of at least STEP_UP_ACR: 1 any, 2 with a second factor, 3 with a
+	// passkey. Adding a second factor only needs the recent sign in, users
+	// without one could not reach the level otherwise. Zero or empty disables
+	// the check.
+	stepUpMaxAge := 10 * time.Minute
+	if val := os.Getenv("STEP_UP_MAX_AGE"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			stepUpMaxAge = duration
+		}
+	}
+
+	// Request budgets per route and client address, email or OAuth client,
+	// e.g. RATE_LIMIT_LOGIN_IP=30/1m. "0" disables a budget. The mail budgets
+	// cover the endpoints that send email.
+	rateLimitRegisterIP := rateLimitEnv("RATE_LIMIT_REGISTER_IP", RateLimit{10, time.Hour})
+	rateLimitRegisterEmail := rateLimitEnv("RATE_LIMIT_REGISTER_EMAIL", RateLimit{5, time.Hour})
+	rateLimitLoginIP := rateLimitEnv("RATE_LIMIT_LOGIN_IP", RateLimit{30, time.Minute})
+	rateLimitLoginEmail := rateLimitEnv("RATE_LIMIT_LOGIN_EMAIL", RateLimit{10, time.Minute})
+	rateLimitRefreshIP := rateLimitEnv("RATE_LIMIT_REFRESH_IP", RateLimit{60, time.Minute})
+	rateLimitVerifyIP := rateLimitEnv("RATE_LIMIT_VERIFY_IP", RateLimit{600, time.Minute})
+	rateLimitTokenIP := rateLimitEnv("RATE_LIMIT_TOKEN_IP", RateLimit{120, time.Minute})
+	rateLimitTokenClient := rateLimitEnv("RATE_LIMIT_TOKEN_CLIENT", RateLimit{600, time.Minute})
+	rateLimitMailIP := rateLimitEnv("RATE_LIMIT_MAIL_IP", RateLimit{20, time.Hour})
+	rateLimitMailEmail := rateLimitEnv("RATE_LIMIT_MAIL_EMAIL", RateLimit{5, time.Hour})
+
+	signingAlg := os.Getenv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = "HS256"
+	}
+
+	// TOTP secrets, signing keys and queued mail are stored encrypted with
+	// ENCRYPTION_KEY, 32 bytes in base64. Without it the key is derived from
+	// JWT_SECRET.
+	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+
 	return Config{
-		JWTSecret:             os.Getenv("JWT_SECRET"),
-		JWTExpiration:         time.Hour * 24,
-		DatabaseURL:           os.Getenv("DATABASE_URL"),
-		RedisURL:              os.Getenv("REDIS_URL"),
-		ServerPort:            os.Getenv("SERVER_PORT"),
-		GoogleCredentialsPath: os.Getenv("GOOGLE_CREDENTIALS_PATH"),
-		GoogleDriveLogFolder:  os.Getenv("GOOGLE_DRIVE_LOG_FOLDER"),
-		LogExportInterval:     logExportInterval,
+		JWTSecret:               os.Getenv("JWT_SECRET"),
+		JWTSigningAlg:           signingAlg,
+		JWTPrivateKeyPath:       os.Getenv("JWT_PRIVATE_KEY_PATH"),
+		JWTKeyID:                os.Getenv("JWT_KEY_ID"),
+		EncryptionKey:           encryptionKey,
+		KeyReloadInterval:       keyReloadInterval,
+		JWTExpiration:           time.Hour * 24,
+		Issuer:                  issuer,
+		SessionDuration:         sessionDuration,
+		CORSOrigins:             corsOrigins,
+		TrustedProxies:          trustedProxies,
+		EmailVerification:       emailVerification,
+		MailTransport:           mailTransport,
+		MailFrom:                mailFrom,
+		SMTPHost:                os.Getenv("SMTP_HOST"),
+		SMTPPort:                smtpPort,
+		SMTPUsername:            os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:            os.Getenv("SMTP_PASSWORD"),
+		SMTPImplicitTLS:         os.Getenv("SMTP_TLS") == "implicit",
+		SMTPRequireTLS:          smtpRequireTLS,
+		MaildirPath:             maildirPath,
+		PasswordMinLength:       passwordMinLength,
+		PasswordMaxLength:       passwordMaxLength,
+		PasswordClasses:         passwordClasses,
+		PasswordBannedFile:      os.Getenv("PASSWORD_BANNED_FILE"),
+		PasswordBreachDataset:   os.Getenv("PASSWORD_BREACH_DATASET"),
+		PasswordBreachAction:    passwordBreachAction,
+		PasswordHash:            passwordHash,
+		BcryptCost:              bcryptCost,
+		Argon2Memory:            argon2Memory,
+		Argon2Iterations:        argon2Iterations,
+		Argon2Parallelism:       argon2Parallelism,
+		Argon2MemoryLimit:       argon2MemoryLimit,
+		TOTPIssuer:              totpIssuer,
+		WebAuthnRPID:            webAuthnRPID,
+		WebAuthnRPName:          webAuthnRPName,
+		WebAuthnOrigins:         webAuthnOrigins,
+		LoginFailureWindow:      loginFailureWindow,
+		LoginBackoffAfter:       loginBackoffAfter,
+		LoginLockoutThreshold:   loginLockoutThreshold,
+		LoginIPLockoutThreshold: loginIPLockoutThreshold,
+		LoginLockoutDuration:    loginLockoutDuration,
+		StepUpMaxAge:            stepUpMaxAge,
+		StepUpACR:               os.Getenv("STEP_UP_ACR"),
+		RateLimitRegisterIP:     rateLimitRegisterIP,
+		RateLimitRegisterEmail:  rateLimitRegisterEmail,
+		RateLimitLoginIP:        rateLimitLoginIP,
+		RateLimitLoginEmail:     rateLimitLoginEmail,
+		RateLimitRefreshIP:      rateLimitRefreshIP,
+		RateLimitVerifyIP:       rateLimitVerifyIP,
+		RateLimitTokenIP:        rateLimitTokenIP,
+		RateLimitTokenClient:    rateLimitTokenClient,
+		RateLimitMailIP:         rateLimitMailIP,
+		RateLimitMailEmail:      rateLimitMailEmail,
+		DatabaseURL:             os.Getenv("DATABASE_URL"),
+		RedisURL:                os.Getenv("REDIS_URL"),
+		ServerPort:              os.Getenv("SERVER_PORT"),
+		GoogleCredentialsPath:   os.Getenv("GOOGLE_CREDENTIALS_PATH"),
+		GoogleDriveLogFolder:    os.Getenv("GOOGLE_DRIVE_LOG_FOLDER"),
+		LogExportInterval:       logExportInterval,
 	}, nil
 }
+
+// rateLimitEnv parses "<limit>/<period>" from the environment variable name,
+// falling back to def if it is unset or malformed.
+func rateLimitEnv(name string, def RateLimit) RateLimit {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+	if val == "0" {
+		return RateLimit{}
+	}
+
+	limit, period, ok := strings.Cut(val, "/")
+	if !ok {
+		return def
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil {
+		return def
+	}
+
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return def
+	}
+
+	return RateLimit{Limit: n, Period: duration}
+}
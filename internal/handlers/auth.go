@@ -2,28 +2,162 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"net/mail"
 	"strings"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"sso/internal/config"
+	"sso/internal/mailer"
+	"sso/internal/middleware"
 	"sso/internal/models"
+	"sso/internal/password"
 	"sso/internal/repository"
+	"sso/internal/webauthn"
 	"sso/pkg/token"
 )
 
+var (
+	errInvalidCredentials    = errors.New("invalid credentials")
+	errAccountDisabled       = errors.New("account disabled")
+	errPasswordResetRequired = errors.New("password reset required")
+	errEmailNotVerified      = errors.New("email not verified")
+)
+
+// loginErrorMessage is shown to users whose sign in was rejected.
+func loginErrorMessage(err error) string {
+	var lockoutErr *lockoutError
+	switch {
+	case errors.As(err, &lockoutErr) && lockoutErr.locked:
+		return "Too many failed sign in attempts, the account is temporarily locked"
+	case errors.As(err, &lockoutErr):
+		return "Too many failed sign in attempts, try again later"
+	case errors.Is(err, errMFAChallengeExpired):
+		return "The sign in has expired, please start again"
+	case errors.Is(err, errMFAInvalidCode), errors.Is(err, errMFACodeReused), errors.Is(err, errEmailLoginInvalidCode):
+		return "Invalid code"
+	case errors.Is(err, errEmailLoginExpired):
+		return "The code or link has expired, please request a new one"
+	case passkeyRejected(err):
+		return "The passkey was not accepted"
+	case errors.Is(err, errAccountDisabled):
+		return "Account disabled"
+	case errors.Is(err, errPasswordResetRequired):
+		return "Password reset required"
+	case errors.Is(err, errEmailNotVerified):
+		return "Email address not verified"
+	default:
+		return "Invalid credentials"
+	}
+}
+
 type AuthHandler struct {
-	userRepo     repository.UserRepository
-	logRepo      repository.LogRepository
-	tokenManager *token.JWTManager
+	userRepo          repository.UserRepository
+	logRepo           repository.LogRepository
+	roleRepo          repository.RoleRepository
+	tokenManager      *token.JWTManager
+	mailer            mailer.Mailer
+	passwordPolicy    *password.Policy
+	hasher            *password.Hasher
+	guard             *LoginGuard
+	mfaRepo           repository.MFARepository
+	challengeRepo     repository.MFAChallengeRepository
+	webauthnSessions  repository.WebAuthnSessionRepository
+	relyingParty      *webauthn.RelyingParty
+	emailLoginRepo    repository.EmailLoginRepository
+	emailVerification string
 }
 
-func NewAuthHandler(userRepo repository.UserRepository, logRepo repository.LogRepository, tokenManager *token.JWTManager) *AuthHandler {
+func NewAuthHandler(
+	userRepo repository.UserRepository,
+	logRepo repository.LogRepository,
+	roleRepo repository.RoleRepository,
+	tokenManager *token.JWTManager,
+	mailer mailer.Mailer,
+	passwordPolicy *password.Policy,
+	hasher *password.Hasher,
+	guard *LoginGuard,
+	mfaRepo repository.MFARepository,
+	challengeRepo repository.MFAChallengeRepository,
+	webauthnSessions repository.WebAuthnSessionRepository,
+	relyingParty *webauthn.RelyingParty,
+	emailLoginRepo repository.EmailLoginRepository,
+	emailVerification string,
+) *AuthHandler {
 	return &AuthHandler{
-		userRepo:     userRepo,
-		logRepo:      logRepo,
-		tokenManager: tokenManager,
+		userRepo:          userRepo,
+		logRepo:           logRepo,
+		roleRepo:          roleRepo,
+		tokenManager:      tokenManager,
+		mailer:            mailer,
+		passwordPolicy:    passwordPolicy,
+		hasher:            hasher,
+		guard:             guard,
+		mfaRepo:           mfaRepo,
+		challengeRepo:     challengeRepo,
+		webauthnSessions:  webauthnSessions,
+		relyingParty:      relyingParty,
+		emailLoginRepo:    emailLoginRepo,
+		emailVerification: emailVerification,
+	}
+}
+
+// allowedScopes returns the scopes that may be granted on behalf of a user.
+// Unverified users only get the basic scopes under the "restrict" policy.
+func (h *AuthHandler) allowedScopes(userID uint) ([]string, error) {
+	if h.emailVerification == config.EmailVerificationRestrict {
+		user, err := h.userRepo.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		if !user.EmailVerified {
+			return append([]string{}, unverifiedScopes...), nil
+		}
+	}
+
+	permissions, err := h.roleRepo.GetUserPermissions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := append([]string{}, userScopes...)
+	if len(permissions) > 0 {
+		allowed = append(allowed, adminScope)
 	}
+
+	return allowed, nil
+}
+
+// requestedScope bounds the scope requested at login by the scopes allowed to
+// the user. Without a request only the default scopes are granted.
+func (h *AuthHandler) requestedScope(userID uint, requested string) (string, error) {
+	allowed, err := h.allowedScopes(userID)
+	if err != nil {
+		return "", err
+	}
+
+	return grantScopes(defaultScope(requested), allowed), nil
+}
+
+// registerScope is requestedScope for an account that is about to be
+// registered, which has no roles and has not verified its email address.
+func (h *AuthHandler) registerScope(requested string) string {
+	allowed := userScopes
+	if h.emailVerification == config.EmailVerificationRestrict {
+		allowed = unverifiedScopes
+	}
+
+	return grantScopes(defaultScope(requested), allowed)
+}
+
+func defaultScope(requested string) string {
+	if requested == "" {
+		return strings.Join(defaultScopes, " ")
+	}
+	return requested
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -33,6 +167,23 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.passwordPolicy.Check(req.Password); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	// Checked before the account is created, so the request can be retried.
+	scope := h.registerScope(req.Scope)
+	if scope == "" {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+
 	existingUser, err := h.userRepo.GetUserByEmail(req.Email)
 	if err == nil && existingUser != nil {
 		http.Error(w, "User already exists", http.StatusConflict)
@@ -45,19 +196,42 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	warnings := screenBreach(h.passwordPolicy, h.userRepo, user.ID, req.Password, false)
+
 	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
 		UserID:    user.ID,
 		Email:     user.Email,
 		Success:   true,
-		IP:        getIP(r),
+		IP:        middleware.ClientIP(r),
 		UserAgent: r.UserAgent(),
+		Method:    repository.LoginMethodPassword,
 	})
 
-	tokenResp, err := h.tokenManager.Generate(user.ID)
+	if h.emailVerification != config.EmailVerificationOff {
+		if err := h.sendVerificationEmail(r, user); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}
+
+	if h.emailVerification == config.EmailVerificationBlock {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		response := map[string]interface{}{
+			"message": "Check your email to verify your address before signing in",
+		}
+		if len(warnings) > 0 {
+			response["warnings"] = warnings
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	tokenResp, err := h.tokenManager.Generate(user.ID, scope, loginAuthentication(repository.LoginMethodPassword, ""))
 	if err != nil {
 		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
 		return
 	}
+	tokenResp.Warnings = warnings
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -71,65 +245,269 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userRepo.GetUserByEmail(req.Email)
+	user, mfa, err := h.authenticate(r, req.Email, req.Password)
+	var lockoutErr *lockoutError
+	if errors.As(err, &lockoutErr) {
+		writeLockoutError(w, lockoutErr)
+		return
+	}
+	if errors.Is(err, errAccountDisabled) || errors.Is(err, errPasswordResetRequired) || errors.Is(err, errEmailNotVerified) {
+		http.Error(w, loginErrorMessage(err), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
-			Email:     req.Email,
-			Success:   false,
-			IP:        getIP(r),
-			UserAgent: r.UserAgent(),
-		})
-
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	h.issueLogin(w, user, mfa, req.Scope, repository.LoginMethodPassword)
+}
+
+// issueLogin answers a passed first factor with tokens for the requested
+// scope, or with an MFA challenge if the user has a second factor.
+func (h *AuthHandler) issueLogin(w http.ResponseWriter, user *models.User, mfa bool, requested, method string) {
+	scope, err := h.requestedScope(user.ID, requested)
+	if err != nil {
+		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+	if scope == "" {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+
+	// Tokens are issued by LoginMFA once the second factor is passed.
+	if mfa {
+		challenge, err := h.startMFA(user.ID, scope, method)
+		if err != nil {
+			log.Printf("Failed to create MFA challenge: %v", err)
+			http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	tokenResp, err := h.tokenManager.Generate(user.ID, scope, loginAuthentication(method, ""))
+	if err != nil {
+		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResp)
+}
+
+// loginAuthentication describes a sign in that just passed the login method
+// and, unless it is empty, the second factor.
+func loginAuthentication(method, factor string) token.Authentication {
+	methods := []string{amrValue(method)}
+	if factor != "" {
+		if second := amrValue(factor); second != methods[0] {
+			methods = append(methods, second)
+		}
+		methods = append(methods, token.AMRMultiFactor)
+	}
+
+	return token.Authentication{Time: time.Now(), Methods: methods}
+}
+
+// amrValue maps login methods and second factors to the "amr" claim. Passkeys
+// are named "webauthn" either way; codes and links sent by email are one-time
+// passwords too.
+func amrValue(method string) string {
+	switch method {
+	case repository.LoginMethodPassword:
+		return token.AMRPassword
+	case repository.LoginMethodWebAuthn:
+		return token.AMRHardwareKey
+	}
+	return token.AMROTP
+}
+
+// authenticate checks email and password and records the login attempt. It is
+// shared by the JSON API and the hosted login page. mfa reports that the user
+// still has to pass the second factor.
+func (h *AuthHandler) authenticate(r *http.Request, email, password string) (*models.User, bool, error) {
+	user, err := h.userRepo.GetUserByEmail(email)
+	var userID uint
+	if err == nil {
+		userID = user.ID
+	}
+
+	// Blocked attempts are refused before the password is checked, so
+	// guessing goes on at the pace of the backoff.
+	if err := h.checkLockout(r, userID, email, repository.LoginMethodPassword); err != nil {
+		return nil, false, err
+	}
+
+	// Unknown users take as long as a wrong password.
+	if user == nil {
+		h.hasher.VerifyDummy(password)
+		h.failUnknownUser(r, email, repository.LoginMethodPassword)
+		return nil, false, errInvalidCredentials
+	}
+
+	ok, err := h.hasher.Verify(password, user.Password)
+	if err != nil {
+		log.Printf("Failed to verify password of user %d: %v", user.ID, err)
+	}
+	if !ok {
+		h.failLogin(r, user, repository.LoginMethodPassword, "invalid_password")
+		return nil, false, errInvalidCredentials
+	}
+
+	// The plain password is only known now, upgrade outdated hashes.
+	if h.hasher.NeedsRehash(user.Password) {
+		h.rehashPassword(user, password)
+	}
+
+	mfa, err := h.passFirstFactor(r, user, repository.LoginMethodPassword)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, mfa, nil
+}
+
+// passFirstFactor finishes a login once the password or a replacement for it
+// is checked, and records it. mfa reports that the user still has to pass
+// the second factor.
+func (h *AuthHandler) passFirstFactor(r *http.Request, user *models.User, method string) (bool, error) {
+	mfa, err := h.mfaRequired(user.ID)
+	if err != nil {
+		log.Printf("Failed to check second factor of user %d: %v", user.ID, err)
+		return false, err
+	}
+
+	// With a second factor the failures are only forgotten once it is
+	// passed as well, so knowing the password does not allow guessing codes.
+	if !mfa {
+		h.guard.Succeed(user.Email)
+	}
+
+	if err := h.checkAccount(r, user, method); err != nil {
+		return false, err
+	}
+
+	attempt := &repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Method:    method,
+	}
+	if mfa {
+		attempt.Success, attempt.Reason = false, "mfa_required"
+	}
+	h.logRepo.StoreLoginAttempt(attempt)
+
+	return mfa, nil
+}
+
+// checkLockout refuses logins while the account or the address is blocked.
+// userID is zero for unknown users.
+func (h *AuthHandler) checkLockout(r *http.Request, userID uint, email, method string) error {
+	err := h.guard.Check(email, middleware.ClientIP(r))
+	if err != nil {
 		h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
-			UserID:    user.ID,
-			Email:     user.Email,
+			UserID:    userID,
+			Email:     email,
 			Success:   false,
-			IP:        getIP(r),
+			IP:        middleware.ClientIP(r),
 			UserAgent: r.UserAgent(),
+			Method:    method,
+			Reason:    err.(*lockoutError).reason,
 		})
+	}
 
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
+	return err
+}
+
+// failUnknownUser records a login for an unknown email or credential. The
+// email is throttled like an account, only the unlock email is not sent.
+func (h *AuthHandler) failUnknownUser(r *http.Request, email, method string) {
+	reason := "unknown_user"
+	if method == repository.LoginMethodWebAuthn {
+		reason = "webauthn_unknown_credential"
 	}
 
+	h.guard.Fail(email, middleware.ClientIP(r))
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		Email:     email,
+		Success:   false,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Method:    method,
+		Reason:    reason,
+	})
+}
+
+// failLogin records a wrong password or code and emails an unlock link if
+// the account got locked.
+func (h *AuthHandler) failLogin(r *http.Request, user *models.User, method, reason string) {
+	locked := h.guard.Fail(user.Email, middleware.ClientIP(r))
 	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
 		UserID:    user.ID,
 		Email:     user.Email,
-		Success:   true,
-		IP:        getIP(r),
+		Success:   false,
+		IP:        middleware.ClientIP(r),
 		UserAgent: r.UserAgent(),
+		Method:    method,
+		Reason:    reason,
 	})
 
-	tokenResp, err := h.tokenManager.Generate(user.ID)
-	if err != nil {
-		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
-		return
+	if locked {
+		if err := h.sendUnlockEmail(r, user); err != nil {
+			log.Printf("Failed to send unlock email: %v", err)
+		}
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tokenResp)
+// checkAccount rejects accounts that may not sign in. Only call it once the
+// password is known to be right, so the account state is not revealed.
+func (h *AuthHandler) checkAccount(r *http.Request, user *models.User, method string) error {
+	var rejected error
+	var reason string
+	switch {
+	case user.Disabled:
+		rejected, reason = errAccountDisabled, "account_disabled"
+	case user.PasswordResetRequired:
+		rejected, reason = errPasswordResetRequired, "password_reset_required"
+	case !user.EmailVerified && h.emailVerification == config.EmailVerificationBlock:
+		rejected, reason = errEmailNotVerified, "email_not_verified"
+	}
+	if rejected != nil {
+		h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Success:   false,
+			IP:        middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			Method:    method,
+			Reason:    reason,
+		})
+	}
+
+	return rejected
 }
 
-func getIP(r *http.Request) string {
-	// Try X-Forwarded-For header first (for proxies)
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip != "" {
-		return strings.Split(ip, ",")[0]
+func (h *AuthHandler) rehashPassword(user *models.User, password string) {
+	hash, err := h.hasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
 	}
 
-	// Try X-Real-IP header next
-	ip = r.Header.Get("X-Real-IP")
-	if ip != "" {
-		return ip
+	if err := h.userRepo.SetPasswordHash(user.ID, hash); err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+		return
 	}
 
-	// Fall back to RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
+	user.Password = hash
 }
 
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
@@ -139,21 +517,23 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, claims, err := h.tokenManager.ValidateToken(req.RefreshToken)
-	if err != nil {
-		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+	if err := h.checkRefreshUser(req.RefreshToken); err != nil {
+		http.Error(w, loginErrorMessage(err), http.StatusUnauthorized)
 		return
 	}
 
-	if claims["type"] != "refresh" {
+	tokenResp, opts, err := h.tokenManager.Refresh(req.RefreshToken, "", token.Lifetimes{})
+	if errors.Is(err, token.ErrRefreshTokenReused) {
+		h.logRefreshReuse(r, opts.UserID)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, token.ErrInvalidTokenType) {
 		http.Error(w, "Invalid token type", http.StatusUnauthorized)
 		return
 	}
-
-	userID := uint(claims["user_id"].(float64))
-	tokenResp, err := h.tokenManager.Generate(userID)
 	if err != nil {
-		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
@@ -161,24 +541,68 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tokenResp)
 }
 
+// checkRefreshUser rejects refresh tokens of disabled or deleted users before
+// they are rotated. Invalid tokens are left to Refresh.
+func (h *AuthHandler) checkRefreshUser(refreshToken string) error {
+	_, claims, err := h.tokenManager.ValidateToken(refreshToken)
+	if err != nil {
+		return nil
+	}
+
+	userID, ok := token.UserID(claims)
+	if !ok {
+		return nil
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return errInvalidCredentials
+	}
+	if user.Disabled {
+		return errAccountDisabled
+	}
+
+	return nil
+}
+
+func (h *AuthHandler) logRefreshReuse(r *http.Request, userID uint) {
+	attempt := &repository.LoginAttempt{
+		UserID:    userID,
+		Success:   false,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventTokenReuse,
+		Reason:    "refresh_token_reuse",
+	}
+	if user, err := h.userRepo.GetUserByID(userID); err == nil {
+		attempt.Email = user.Email
+	}
+	h.logRepo.StoreLoginAttempt(attempt)
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
 func (h *AuthHandler) VerifyToken(w http.ResponseWriter, r *http.Request) {
-	tokenString := r.Header.Get("Authorization")
+	tokenString := bearerToken(r)
 	if tokenString == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if strings.HasPrefix(tokenString, "Bearer ") {
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-	}
-
 	_, claims, err := h.tokenManager.ValidateToken(tokenString)
 	if err != nil {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
 
-	userID := uint(claims["user_id"].(float64))
+	// ID tokens and client credentials tokens are not issued to a user.
+	userID, ok := token.UserID(claims)
+	if !ok {
+		http.Error(w, "Invalid token type", http.StatusUnauthorized)
+		return
+	}
 
 	response := models.TokenVerifyResponse{
 		Valid:  true,
@@ -188,3 +612,47 @@ func (h *AuthHandler) VerifyToken(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// Logout revokes the presented access token and, if given, the refresh token
+// together with its family.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, claims, err := h.tokenManager.ValidateToken(tokenString)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if claims["type"] != "access" {
+		http.Error(w, "Invalid token type", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.tokenManager.Revoke(claims); err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		_, refreshClaims, err := h.tokenManager.ValidateToken(req.RefreshToken)
+		if err == nil && refreshClaims["type"] == "refresh" && refreshClaims["user_id"] == claims["user_id"] {
+			if err := h.tokenManager.Revoke(refreshClaims); err != nil {
+				http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/models"
+	"sso/pkg/token"
+)
+
+const authCodeTTL = time.Minute
+
+type authorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+	MaxAge              string
+	ACRValues           string
+}
+
+func parseAuthorizeRequest(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		ResponseType:        r.FormValue("response_type"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		Nonce:               r.FormValue("nonce"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Prompt:              r.FormValue("prompt"),
+		MaxAge:              r.FormValue("max_age"),
+		ACRValues:           r.FormValue("acr_values"),
+	}
+}
+
+// hidden carries the authorization request through the login form.
+func (req authorizeRequest) hidden() map[string]string {
+	return map[string]string{
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+		"max_age":               req.MaxAge,
+		"acr_values":            req.ACRValues,
+	}
+}
+
+// requiredACR returns the weakest of the requested context classes, which
+// any sign in at least as strong satisfies. Unknown values are ignored.
+func (req authorizeRequest) requiredACR() string {
+	var required string
+	for _, acr := range strings.Fields(req.ACRValues) {
+		if token.ValidACR(acr) && (required == "" || token.SatisfiesACR(required, acr)) {
+			required = acr
+		}
+	}
+	return required
+}
+
+// satisfiedBy reports whether a sign in is recent enough for max_age and
+// strong enough for acr_values.
+func (req authorizeRequest) satisfiedBy(auth token.Authentication) bool {
+	if req.MaxAge != "" {
+		seconds, _ := strconv.Atoi(req.MaxAge)
+		if time.Since(auth.Time) > time.Duration(seconds)*time.Second {
+			return false
+		}
+	}
+
+	if acr := req.requiredACR(); acr != "" && !token.SatisfiesACR(auth.ACR(), acr) {
+		return false
+	}
+
+	return true
+}
+
+// acrMessage tells the user why a sign in was not strong enough.
+func acrMessage(acr string) string {
+	if acr == token.ACRPhishingResistant {
+		return "This application requires signing in with a passkey"
+	}
+	return "This application requires signing in with a second factor"
+}
+
+// Authorize is the OpenID Connect authorization endpoint. GET shows the hosted
+// login page unless the browser already has a session, POST submits it.
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	req := parseAuthorizeRequest(r)
+
+	// Never redirect to an unverified URI, show the error instead.
+	client, err := h.clientRepo.GetClientByClientID(req.ClientID)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Unknown client")
+		return
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		renderError(w, http.StatusBadRequest, "Invalid redirect URI")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		redirectWithError(w, r, req, "unauthorized_client", "The client may not use the authorization code grant")
+		return
+	}
+	if req.ResponseType != "code" {
+		redirectWithError(w, r, req, "unsupported_response_type", "Only the code response type is supported")
+		return
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		redirectWithError(w, r, req, "invalid_request", "PKCE with the S256 method is required")
+		return
+	}
+	if seconds, err := strconv.Atoi(req.MaxAge); req.MaxAge != "" && (err != nil || seconds < 0) {
+		redirectWithError(w, r, req, "invalid_request", "max_age must be a number of seconds")
+		return
+	}
+
+	req.Scope = grantScopes(req.Scope, client.Scopes)
+	if hasScope(req.Scope, "openid") && !h.tokenManager.SignsIDTokens() {
+		redirectWithError(w, r, req, "invalid_scope", "openid needs an asymmetric signing key, set JWT_SIGNING_ALG")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// Without the token other sites could sign the browser in to their
+		// own account.
+		if !checkCSRF(r) {
+			h.renderLogin(w, r, http.StatusForbidden, client, req, r.PostFormValue("email"), "The sign in page has expired, please sign in again", "")
+			return
+		}
+
+		user, auth := h.signIn(w, r, func(status int, email, message, mfaToken string) {
+			h.renderLogin(w, r, status, client, req, email, message, mfaToken)
+		})
+		if user == nil {
+			return
+		}
+
+		// A fresh sign in is always recent, but may be too weak.
+		if acr := req.requiredACR(); acr != "" && !token.SatisfiesACR(auth.ACR(), acr) {
+			h.renderLogin(w, r, http.StatusForbidden, client, req, user.Email, acrMessage(acr), "")
+			return
+		}
+
+		session, err := h.startSession(w, user.ID, auth)
+		if err != nil {
+			log.Printf("Failed to create session: %v", err)
+			renderError(w, http.StatusInternalServerError, "Failed to sign in")
+			return
+		}
+
+		h.issueCode(w, r, req, session)
+		return
+	}
+
+	// A session too old for max_age or too weak for acr_values is treated
+	// like no session, the user has to sign in again.
+	session := h.currentSession(r)
+	if session != nil && !req.satisfiedBy(token.Authentication{Time: session.AuthTime, Methods: session.AMR}) {
+		session = nil
+	}
+	if session == nil && req.Prompt == "none" {
+		redirectWithError(w, r, req, "login_required", "")
+		return
+	}
+	if session == nil || req.Prompt == "login" {
+		h.renderLogin(w, r, http.StatusOK, client, req, "", "", "")
+		return
+	}
+
+	h.issueCode(w, r, req, session)
+}
+
+func (h *OAuthHandler) renderLogin(w http.ResponseWriter, r *http.Request, status int, client *models.OAuthClient, req authorizeRequest, email, message, mfaToken string) {
+	csrf, err := h.csrfToken(w, r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	hidden := req.hidden()
+	hidden[csrfFieldName] = csrf
+	if mfaToken != "" {
+		hidden["mfa_token"] = mfaToken
+	}
+
+	renderPage(w, status, "login.html", pageData{
+		Title:      "Sign in",
+		Action:     "/authorize",
+		ClientName: client.Name,
+		Email:      email,
+		Error:      message,
+		MFA:        mfaToken != "",
+		Hidden:     hidden,
+	})
+}
+
+func (h *OAuthHandler) issueCode(w http.ResponseWriter, r *http.Request, req authorizeRequest, session *models.Session) {
+	allowed, err := h.authHandler.allowedScopes(session.UserID)
+	if err != nil {
+		log.Printf("Failed to load allowed scopes: %v", err)
+		redirectWithError(w, r, req, "server_error", "")
+		return
+	}
+
+	code, err := h.codeRepo.CreateCode(&models.AuthorizationCode{
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		UserID:              session.UserID,
+		Scope:               grantScopes(req.Scope, allowed),
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            session.AuthTime,
+		AMR:                 session.AMR,
+	}, authCodeTTL)
+	if err != nil {
+		log.Printf("Failed to store authorization code: %v", err)
+		redirectWithError(w, r, req, "server_error", "")
+		return
+	}
+
+	redirectTo(w, r, req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	})
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	params := url.Values{
+		"error": {code},
+		"state": {req.State},
+	}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+
+	redirectTo(w, r, req.RedirectURI, params)
+}
+
+// redirectTo appends params to the query of a registered redirect URI.
+func redirectTo(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid redirect URI")
+		return
+	}
+
+	query := target.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+// ClientHandler is the admin API of the OAuth client registry.
+type ClientHandler struct {
+	clientRepo   repository.ClientRepository
+	tokenManager *token.JWTManager
+}
+
+func NewClientHandler(clientRepo repository.ClientRepository, tokenManager *token.JWTManager) *ClientHandler {
+	return &ClientHandler{
+		clientRepo:   clientRepo,
+		tokenManager: tokenManager,
+	}
+}
+
+func (h *ClientHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.clientRepo.ListClients()
+	if err != nil {
+		http.Error(w, "Failed to retrieve clients", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+func (h *ClientHandler) GetClient(w http.ResponseWriter, r *http.Request) {
+	client, err := h.clientRepo.GetClientByClientID(mux.Vars(r)["client_id"])
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+// CreateClient registers a client. The generated secret of a confidential
+// client is returned only in this response.
+func (h *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var req models.ClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ClientID == "" {
+		clientID, err := repository.RandomToken(16)
+		if err != nil {
+			http.Error(w, "Failed to create client", http.StatusInternalServerError)
+			return
+		}
+		req.ClientID = clientID
+	}
+	if req.GrantTypes == nil {
+		req.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	}
+	if req.Scopes == nil {
+		req.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	if err := h.validateClientRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.clientRepo.GetClientByClientID(req.ClientID); err == nil {
+		http.Error(w, "Client already exists", http.StatusConflict)
+		return
+	}
+
+	client := &models.OAuthClient{ClientID: req.ClientID}
+	applyClientRequest(client, req)
+
+	var secret string
+	if req.Confidential {
+		var err error
+		secret, err = repository.RandomToken(32)
+		if err != nil {
+			http.Error(w, "Failed to create client", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := h.clientRepo.CreateClient(client, secret); err != nil {
+		http.Error(w, "Failed to create client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.ClientSecretResponse{Client: client, ClientSecret: secret})
+}
+
+// UpdateClient replaces the settings of a client. The client id and secret
+// cannot be changed here.
+func (h *ClientHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	client, err := h.clientRepo.GetClientByClientID(mux.Vars(r)["client_id"])
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.ClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.validateClientRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyClientRequest(client, req)
+
+	if err := h.clientRepo.UpdateClient(client); err != nil {
+		http.Error(w, "Failed to update client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+func (h *ClientHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	err := h.clientRepo.DeleteClient(mux.Vars(r)["client_id"])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete client", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateSecret replaces the secret of a confidential client.
+func (h *ClientHandler) RegenerateSecret(w http.ResponseWriter, r *http.Request) {
+	client, err := h.clientRepo.GetClientByClientID(mux.Vars(r)["client_id"])
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	if !client.Confidential() {
+		http.Error(w, "Public clients have no secret", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := repository.RandomToken(32)
+	if err != nil {
+		http.Error(w, "Failed to update client", http.StatusInternalServerError)
+		return
+	}
+	if err := h.clientRepo.SetClientSecret(client, secret); err != nil {
+		http.Error(w, "Failed to update client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ClientSecretResponse{Client: client, ClientSecret: secret})
+}
+
+func (h *ClientHandler) validateClientRequest(req models.ClientRequest) error {
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+
+	for _, uri := range append(append([]string{}, req.RedirectURIs...), req.AllowedOrigins...) {
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Fragment != "" {
+			return fmt.Errorf("invalid URI %q", uri)
+		}
+	}
+
+	for _, grantType := range req.GrantTypes {
+		if !contains(models.SupportedGrantTypes, grantType) {
+			return fmt.Errorf("unsupported grant type %q", grantType)
+		}
+	}
+	if contains(req.GrantTypes, models.GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return errors.New("authorization_code clients need at least one redirect URI")
+	}
+
+	for _, scope := range req.Scopes {
+		if !contains(supportedScopes, scope) {
+			return fmt.Errorf("unsupported scope %q", scope)
+		}
+	}
+
+	// Clients may only shorten lifetimes, so retired signing keys outlive
+	// every token they signed.
+	if req.AccessTokenTTL < 0 || time.Duration(req.AccessTokenTTL)*time.Second > h.tokenManager.TokenDuration {
+		return fmt.Errorf("access_token_ttl must be between 0 and %d", int(h.tokenManager.TokenDuration.Seconds()))
+	}
+	if req.RefreshTokenTTL < 0 || time.Duration(req.RefreshTokenTTL)*time.Second > h.tokenManager.RefreshDuration {
+		return fmt.Errorf("refresh_token_ttl must be between 0 and %d", int(h.tokenManager.RefreshDuration.Seconds()))
+	}
+
+	return nil
+}
+
+func applyClientRequest(client *models.OAuthClient, req models.ClientRequest) {
+	client.Name = req.Name
+	client.RedirectURIs = req.RedirectURIs
+	client.GrantTypes = req.GrantTypes
+	client.Scopes = req.Scopes
+	client.AllowedOrigins = req.AllowedOrigins
+	client.AccessTokenTTL = req.AccessTokenTTL
+	client.RefreshTokenTTL = req.RefreshTokenTTL
+}
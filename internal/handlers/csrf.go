@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"sso/internal/repository"
+)
+
+const (
+	csrfCookieName = "sso_csrf"
+	csrfFieldName  = "csrf_token"
+)
+
+// csrfToken returns the token hosted forms post back in csrf_token, setting
+// the cookie it is checked against on the first visit. Other sites can make
+// the browser send the cookie but cannot read the token from the page.
+func (h *OAuthHandler) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	value, err := repository.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.tokenManager.Issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return value, nil
+}
+
+// checkCSRF reports whether a posted hosted form carries the token of the
+// browser's cookie.
+func checkCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	posted := r.PostFormValue(csrfFieldName)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(posted)) == 1
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+const (
+	deviceCodeTTL      = 10 * time.Minute
+	devicePollInterval = 5 * time.Second
+)
+
+// DeviceAuthorization is the device authorization endpoint (RFC 8628). The
+// user enters the returned user code on the hosted /device page while the
+// device polls /token with the device code.
+func (h *OAuthHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+		return
+	}
+
+	client, err := h.tokenClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantDeviceCode) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	auth := &models.DeviceAuthorization{
+		ClientID: client.ClientID,
+		Scope:    grantScopes(r.PostFormValue("scope"), client.Scopes),
+	}
+	if hasScope(auth.Scope, "openid") && !h.tokenManager.SignsIDTokens() {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "openid needs an asymmetric signing key, set JWT_SIGNING_ALG")
+		return
+	}
+
+	deviceCode, err := h.deviceRepo.CreateDeviceCode(auth, deviceCodeTTL)
+	if err != nil {
+		log.Printf("Failed to store device code: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	verificationURI := h.tokenManager.Issuer + "/device"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                auth.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {auth.UserCode}}.Encode(),
+		ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+		Interval:                int64(devicePollInterval.Seconds()),
+	})
+}
+
+// Device is the hosted page where a signed in user approves or denies a
+// device. Users without a session sign in first.
+func (h *OAuthHandler) Device(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	userCode := repository.NormalizeUserCode(r.FormValue("user_code"))
+	session := h.currentSession(r)
+
+	// Without the token other sites could sign the browser in to their own
+	// account, which /authorize would then use, or approve their device.
+	if r.Method == http.MethodPost && !checkCSRF(r) {
+		if session == nil {
+			h.renderDeviceLogin(w, r, http.StatusForbidden, userCode, r.PostFormValue("email"), "The sign in page has expired, please sign in again", "")
+			return
+		}
+		renderError(w, http.StatusForbidden, "The page has expired, please enter the code again")
+		return
+	}
+
+	if r.Method == http.MethodPost && (r.PostFormValue("email") != "" || r.PostFormValue("mfa_token") != "" || r.PostFormValue("webauthn") != "") {
+		user, signedIn := h.signIn(w, r, func(status int, email, message, mfaToken string) {
+			h.renderDeviceLogin(w, r, status, userCode, email, message, mfaToken)
+		})
+		if user == nil {
+			return
+		}
+
+		var err error
+		session, err = h.startSession(w, user.ID, signedIn)
+		if err != nil {
+			log.Printf("Failed to create session: %v", err)
+			renderError(w, http.StatusInternalServerError, "Failed to sign in")
+			return
+		}
+	}
+
+	if session == nil {
+		h.renderDeviceLogin(w, r, http.StatusOK, userCode, "", "", "")
+		return
+	}
+
+	if userCode == "" {
+		renderPage(w, http.StatusOK, "device.html", pageData{Title: "Connect a device", Action: "/device"})
+		return
+	}
+
+	auth, err := h.deviceRepo.GetByUserCode(userCode)
+	if err != nil || auth.Status != models.DeviceStatusPending {
+		renderPage(w, http.StatusBadRequest, "device.html", pageData{
+			Title:  "Connect a device",
+			Action: "/device",
+			Error:  "Invalid or expired code",
+		})
+		return
+	}
+
+	client, err := h.clientRepo.GetClientByClientID(auth.ClientID)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Unknown client")
+		return
+	}
+
+	var message string
+	switch r.PostFormValue("action") {
+	case "approve":
+		allowed, err := h.authHandler.allowedScopes(session.UserID)
+		if err != nil {
+			log.Printf("Failed to load allowed scopes: %v", err)
+			renderError(w, http.StatusInternalServerError, "Failed to approve the device")
+			return
+		}
+
+		auth.Status = models.DeviceStatusApproved
+		auth.UserID = session.UserID
+		auth.AuthTime = session.AuthTime
+		auth.AMR = session.AMR
+		auth.Scope = grantScopes(auth.Scope, allowed)
+		message = "Device connected. You can return to " + client.Name + "."
+	case "deny":
+		auth.Status = models.DeviceStatusDenied
+		message = "Access denied."
+	default:
+		csrf, err := h.csrfToken(w, r)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, "Failed to approve the device")
+			return
+		}
+
+		renderPage(w, http.StatusOK, "device.html", pageData{
+			Title:      "Connect a device",
+			Action:     "/device",
+			ClientName: client.Name,
+			UserCode:   userCode,
+			Scopes:     strings.Fields(auth.Scope),
+			Hidden:     map[string]string{"user_code": userCode, csrfFieldName: csrf},
+		})
+		return
+	}
+
+	if err := h.deviceRepo.CompleteUserCode(userCode, auth); err != nil {
+		log.Printf("Failed to complete device code: %v", err)
+		renderError(w, http.StatusInternalServerError, "Invalid or expired code")
+		return
+	}
+
+	renderPage(w, http.StatusOK, "device.html", pageData{Title: "Connect a device", Message: message})
+}
+
+func (h *OAuthHandler) renderDeviceLogin(w http.ResponseWriter, r *http.Request, status int, userCode, email, message, mfaToken string) {
+	csrf, err := h.csrfToken(w, r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	hidden := map[string]string{"user_code": userCode, csrfFieldName: csrf}
+	if mfaToken != "" {
+		hidden["mfa_token"] = mfaToken
+	}
+
+	renderPage(w, status, "login.html", pageData{
+		Title:  "Sign in",
+		Action: "/device",
+		Email:  email,
+		Error:  message,
+		MFA:    mfaToken != "",
+		Hidden: hidden,
+	})
+}
+
+func (h *OAuthHandler) deviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.tokenClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantDeviceCode) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	// Leave a second of slack for network jitter between polls.
+	auth, err := h.deviceRepo.PollDeviceCode(r.PostFormValue("device_code"), devicePollInterval-time.Second)
+	if errors.Is(err, repository.ErrSlowDown) {
+		writeOAuthError(w, http.StatusBadRequest, "slow_down", "")
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "expired_token", "Invalid or expired device code")
+		return
+	}
+
+	if auth.ClientID != client.ClientID {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Device code was issued to another client")
+		return
+	}
+
+	switch auth.Status {
+	case models.DeviceStatusPending:
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending", "")
+	case models.DeviceStatusDenied:
+		writeOAuthError(w, http.StatusBadRequest, "access_denied", "")
+	default:
+		h.issueUserTokens(w, client, auth.UserID, auth.Scope, "", token.Authentication{Time: auth.AuthTime, Methods: auth.AMR})
+	}
+}
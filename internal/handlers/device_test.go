@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/internal/webauthn"
+	"sso/pkg/token"
+)
+
+const (
+	testRPID   = "sso.example.com"
+	testOrigin = "https://sso.example.com"
+)
+
+// The fakes embed the repository interfaces and implement what the hosted
+// device page uses; anything else panics.
+
+type memUsers struct {
+	repository.UserRepository
+	users map[uint]*models.User
+}
+
+func (r *memUsers) GetUserByID(id uint) (*models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+type memPasskeys struct {
+	repository.MFARepository
+	credentials map[string]*models.WebAuthnCredential
+}
+
+func (r *memPasskeys) GetWebAuthnCredential(credentialID string) (*models.WebAuthnCredential, error) {
+	credential, ok := r.credentials[credentialID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return credential, nil
+}
+
+func (r *memPasskeys) UseWebAuthnCredential(id uint, signCount, newSignCount uint32) (bool, error) {
+	for _, credential := range r.credentials {
+		if credential.ID == id && credential.SignCount == signCount {
+			credential.SignCount = newSignCount
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type memWebAuthnSessions struct {
+	sessions map[string]*models.WebAuthnSession
+}
+
+func (r *memWebAuthnSessions) CreateSession(session *models.WebAuthnSession, ttl time.Duration) (string, error) {
+	id, err := repository.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	r.sessions[id] = session
+	return id, nil
+}
+
+func (r *memWebAuthnSessions) ConsumeSession(id string) (*models.WebAuthnSession, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	delete(r.sessions, id)
+	return session, nil
+}
+
+type memSessions struct {
+	repository.SessionRepository
+	sessions map[string]*models.Session
+}
+
+func (r *memSessions) CreateSession(session *models.Session, ttl time.Duration) error {
+	id, err := repository.RandomToken(16)
+	if err != nil {
+		return err
+	}
+	session.ID = id
+	r.sessions[id] = session
+	return nil
+}
+
+func (r *memSessions) GetSession(id string) (*models.Session, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	return session, nil
+}
+
+type memLockout struct{}
+
+func (memLockout) RecordFailure(key string, window time.Duration) (int64, error) { return 1, nil }
+func (memLockout) GetBlock(key string) (repository.LoginBlock, error) {
+	return repository.LoginBlock{}, nil
+}
+func (memLockout) Block(key string, locked bool, ttl time.Duration) error { return nil }
+func (memLockout) Unlock(key string) error                                { return nil }
+
+type memLogs struct {
+	repository.LogRepository
+	attempts []repository.LoginAttempt
+}
+
+func (r *memLogs) StoreLoginAttempt(attempt *repository.LoginAttempt) error {
+	r.attempts = append(r.attempts, *attempt)
+	return nil
+}
+
+type memDevices struct {
+	repository.DeviceCodeRepository
+	authorizations map[string]*models.DeviceAuthorization
+}
+
+func (r *memDevices) GetByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	auth, ok := r.authorizations[userCode]
+	if !ok {
+		return nil, errors.New("code not found")
+	}
+	return auth, nil
+}
+
+type memClients struct {
+	repository.ClientRepository
+	clients map[string]*models.OAuthClient
+}
+
+func (r *memClients) GetClientByClientID(clientID string) (*models.OAuthClient, error) {
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return client, nil
+}
+
+// authenticator is a software authenticator with one discoverable ES256
+// credential of a user.
+type authenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	userID uint
+}
+
+func newAuthenticator(t *testing.T, userID uint) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{key: key, id: []byte("credential-1"), userID: userID}
+}
+
+// coseKey encodes the public key as the CBOR map {1: 2, 3: -7, -1: 1,
+// -2: x, -3: y}.
+func (a *authenticator) coseKey() []byte {
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, a.key.X.FillBytes(make([]byte, 32))...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, a.key.Y.FillBytes(make([]byte, 32))...)
+}
+
+// assert answers RequestOptions with the user verified.
+func (a *authenticator) assert(t *testing.T, challenge []byte, signCount uint32) *webauthn.AssertionResponse {
+	t.Helper()
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	authData := append(rpIDHash[:], webauthn.FlagUserPresent|webauthn.FlagUserVerified)
+	authData = binary.BigEndian.AppendUint32(authData, signCount)
+
+	clientData, _ := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.id),
+		RawID: a.id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = webAuthnUserHandle(a.userID)
+	return resp
+}
+
+func TestDeviceSignInWithPasskey(t *testing.T) {
+	user := &models.User{ID: 7, Email: "user@example.com", EmailVerified: true}
+	passkey := newAuthenticator(t, user.ID)
+
+	webauthnSessions := &memWebAuthnSessions{sessions: map[string]*models.WebAuthnSession{}}
+	sessions := &memSessions{sessions: map[string]*models.Session{}}
+	logs := &memLogs{}
+	tokenManager := token.NewJWTManager(nil, testOrigin, time.Hour, nil, nil, nil)
+
+	authHandler := NewAuthHandler(
+		&memUsers{users: map[uint]*models.User{user.ID: user}},
+		logs,
+		nil,
+		tokenManager,
+		nil,
+		nil,
+		nil,
+		NewLoginGuard(memLockout{}, LockoutPolicy{}),
+		&memPasskeys{credentials: map[string]*models.WebAuthnCredential{
+			base64.RawURLEncoding.EncodeToString(passkey.id): {ID: 1, UserID: user.ID, PublicKey: passkey.coseKey()},
+		}},
+		nil,
+		webauthnSessions,
+		webauthn.NewRelyingParty(testRPID, "SSO", []string{testOrigin}),
+		nil,
+		"",
+	)
+	oauthHandler := NewOAuthHandler(
+		&memClients{clients: map[string]*models.OAuthClient{"tv": {ClientID: "tv", Name: "Living room TV"}}},
+		nil,
+		sessions,
+		nil,
+		&memDevices{authorizations: map[string]*models.DeviceAuthorization{
+			"BCDF-GHJK": {ClientID: "tv", Scope: "openid email", UserCode: "BCDF-GHJK", Status: models.DeviceStatusPending},
+		}},
+		authHandler,
+		tokenManager,
+		time.Hour,
+	)
+
+	options, err := authHandler.requestPasskey(0, models.WebAuthnLogin, "required", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion, err := json.Marshal(passkey.assert(t, options.PublicKey.Challenge, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{
+		"user_code":        {"BCDF-GHJK"},
+		"webauthn_session": {options.Session},
+		"webauthn":         {string(assertion)},
+		csrfFieldName:      {"csrf"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "csrf"})
+	rec := httptest.NewRecorder()
+
+	oauthHandler.Device(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Living room TV") || strings.Contains(body, `name="password"`) {
+		t.Errorf("want the approval page, got %s", body)
+	}
+
+	var session *models.Session
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = sessions.sessions[cookie.Value]
+		}
+	}
+	if session == nil || session.UserID != user.ID {
+		t.Fatalf("session = %+v, want a session of user %d", session, user.ID)
+	}
+	if len(session.AMR) == 0 || session.AMR[0] != amrValue(repository.LoginMethodWebAuthn) {
+		t.Errorf("AMR = %v, want the passkey", session.AMR)
+	}
+	if len(logs.attempts) != 1 || !logs.attempts[0].Success || logs.attempts[0].Method != repository.LoginMethodWebAuthn {
+		t.Errorf("login attempts = %+v, want one successful passkey login", logs.attempts)
+	}
+}
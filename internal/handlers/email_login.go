@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sso/internal/mailer"
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+const (
+	emailLoginCodeTTL     = 10 * time.Minute
+	emailLoginLinkTTL     = 15 * time.Minute
+	emailLoginMaxAttempts = 5
+
+	emailLoginCode = "code"
+	emailLoginLink = "link"
+)
+
+var (
+	errEmailLoginExpired     = errors.New("email login expired")
+	errEmailLoginInvalidCode = errors.New("invalid email login code")
+)
+
+// LoginEmail sends a sign in code or link instead of asking for a password.
+// The answer is the same for unknown emails, and the email is looked up and
+// sent after answering, so they cannot be told apart by the timing either.
+func (h *AuthHandler) LoginEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	method := req.Method
+	if method == "" {
+		method = emailLoginCode
+	}
+	if method != emailLoginCode && method != emailLoginLink {
+		http.Error(w, "Invalid method", http.StatusBadRequest)
+		return
+	}
+	// Only scopes nobody could get are refused here. Those the user may not
+	// get are dropped without an email, so the answer stays the same.
+	if grantScopes(defaultScope(req.Scope), loginScopes) == "" {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+
+	locale := mailer.Locale(r.Header.Get("Accept-Language"))
+	go func() {
+		user, err := h.userRepo.GetUserByEmail(req.Email)
+		if err != nil || user.Disabled {
+			return
+		}
+		scope, err := h.requestedScope(user.ID, req.Scope)
+		if err != nil {
+			log.Printf("Failed to check the scope of a login email: %v", err)
+			return
+		}
+		if scope == "" {
+			return
+		}
+		if err := h.sendLoginEmail(locale, user, method, scope); err != nil {
+			log.Printf("Failed to send login email: %v", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) sendLoginEmail(locale string, user *models.User, method, scope string) error {
+	login := &models.EmailLogin{UserID: user.ID, Scope: scope}
+
+	var msg *mailer.Message
+	if method == emailLoginLink {
+		linkToken, err := h.emailLoginRepo.CreateLink(login, emailLoginLinkTTL)
+		if err != nil {
+			return err
+		}
+
+		msg, err = mailer.Render("login_link", locale, map[string]interface{}{
+			"Link":    h.tokenManager.Issuer + "/api/login/email/verify?" + url.Values{"token": {linkToken}}.Encode(),
+			"Minutes": int(emailLoginLinkTTL.Minutes()),
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		code, err := h.emailLoginRepo.CreateCode(login, emailLoginCodeTTL)
+		if err != nil {
+			return err
+		}
+
+		msg, err = mailer.Render("login_code", locale, map[string]interface{}{
+			"Code":    code,
+			"Minutes": int(emailLoginCodeTTL.Minutes()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	msg.To = user.Email
+	return h.mailer.Send(msg)
+}
+
+// VerifyEmailLogin exchanges the code or the link token for tokens, or for an
+// MFA challenge, like Login does for a password.
+func (h *AuthHandler) VerifyEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailLoginVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, login, mfa, err := h.authenticateEmail(r, &req)
+	var lockoutErr *lockoutError
+	if errors.As(err, &lockoutErr) {
+		writeLockoutError(w, lockoutErr)
+		return
+	}
+	if errors.Is(err, errAccountDisabled) || errors.Is(err, errPasswordResetRequired) || errors.Is(err, errEmailNotVerified) {
+		http.Error(w, loginErrorMessage(err), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, loginErrorMessage(err), http.StatusUnauthorized)
+		return
+	}
+
+	h.issueLogin(w, user, mfa, login.Scope, emailLoginMethod(&req))
+}
+
+func emailLoginMethod(req *models.EmailLoginVerifyRequest) string {
+	if req.Token != "" {
+		return repository.LoginMethodEmailLink
+	}
+	return repository.LoginMethodEmailCode
+}
+
+// authenticateEmail checks a code or link token and records the login
+// attempt. Wrong codes count as failed logins and a code is dropped after
+// emailLoginMaxAttempts of them. mfa reports that the user still has to pass
+// the second factor.
+func (h *AuthHandler) authenticateEmail(r *http.Request, req *models.EmailLoginVerifyRequest) (*models.User, *models.EmailLogin, bool, error) {
+	method := emailLoginMethod(req)
+
+	var user *models.User
+	var login *models.EmailLogin
+	if method == repository.LoginMethodEmailLink {
+		var err error
+		login, err = h.emailLoginRepo.ConsumeLink(req.Token)
+		if err != nil {
+			return nil, nil, false, errEmailLoginExpired
+		}
+
+		user, err = h.userRepo.GetUserByID(login.UserID)
+		if err != nil {
+			return nil, nil, false, errEmailLoginExpired
+		}
+
+		if err := h.checkLockout(r, user.ID, user.Email, method); err != nil {
+			return nil, nil, false, err
+		}
+	} else {
+		user, _ = h.userRepo.GetUserByEmail(req.Email)
+		var userID uint
+		if user != nil {
+			userID = user.ID
+		}
+
+		if err := h.checkLockout(r, userID, req.Email, method); err != nil {
+			return nil, nil, false, err
+		}
+
+		if user == nil {
+			h.failUnknownUser(r, req.Email, method)
+			return nil, nil, false, errEmailLoginInvalidCode
+		}
+
+		var err error
+		login, err = h.emailLoginRepo.UseCode(user.ID, strings.TrimSpace(req.Code), emailLoginMaxAttempts)
+		if errors.Is(err, repository.ErrInvalidCode) {
+			h.failLogin(r, user, method, "invalid_code")
+			return nil, nil, false, errEmailLoginInvalidCode
+		}
+		if err != nil {
+			return nil, nil, false, errEmailLoginExpired
+		}
+	}
+
+	// Receiving the email proves the address.
+	if !user.EmailVerified {
+		if err := h.userRepo.SetEmailVerified(user.ID, true); err != nil {
+			log.Printf("Failed to verify email of user %d: %v", user.ID, err)
+		} else {
+			user.EmailVerified = true
+		}
+	}
+
+	mfa, err := h.passFirstFactor(r, user, method)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return user, login, mfa, nil
+}
+
+// EmailLogin is where sign in links lead. Opening the link shows a page with
+// a button, so mail scanners that prefetch links do not use it up, which
+// signs the browser in to the hosted login session. The form carries a CSRF
+// token, so other sites cannot sign the browser in to their own account.
+// JSON clients POST the token or the code instead and get tokens.
+func (h *OAuthHandler) EmailLogin(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		h.authHandler.VerifyEmailLogin(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		csrf, err := h.csrfToken(w, r)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, "Failed to sign in")
+			return
+		}
+
+		renderPage(w, http.StatusOK, "email_login.html", pageData{
+			Title:  "Sign in",
+			Action: "/api/login/email/verify",
+			Hidden: map[string]string{"token": r.URL.Query().Get("token"), csrfFieldName: csrf},
+		})
+		return
+	}
+
+	if !checkCSRF(r) {
+		renderError(w, http.StatusForbidden, "The sign in page has expired, please open the link again")
+		return
+	}
+
+	render := func(status int, email, message, mfaToken string) {
+		if mfaToken == "" {
+			renderError(w, status, message)
+			return
+		}
+		renderPage(w, status, "login.html", pageData{
+			Title:  "Sign in",
+			Action: "/api/login/email/verify",
+			Error:  message,
+			MFA:    true,
+			Hidden: map[string]string{"mfa_token": mfaToken, csrfFieldName: r.PostFormValue(csrfFieldName)},
+		})
+	}
+
+	var user *models.User
+	var auth token.Authentication
+	if r.PostFormValue("mfa_token") != "" {
+		user, auth = h.signIn(w, r, render)
+	} else {
+		req := &models.EmailLoginVerifyRequest{Token: r.PostFormValue("token")}
+
+		var mfa bool
+		var err error
+		user, _, mfa, err = h.authHandler.authenticateEmail(r, req)
+		if err != nil {
+			render(http.StatusUnauthorized, "", loginErrorMessage(err), "")
+			return
+		}
+
+		if mfa {
+			challenge, err := h.authHandler.startMFA(user.ID, "", repository.LoginMethodEmailLink)
+			if err != nil {
+				log.Printf("Failed to create MFA challenge: %v", err)
+				renderError(w, http.StatusInternalServerError, "Failed to sign in")
+				return
+			}
+			render(http.StatusOK, "", "", challenge.MFAToken)
+			return
+		}
+		auth = loginAuthentication(repository.LoginMethodEmailLink, "")
+	}
+	if user == nil {
+		return
+	}
+
+	if _, err := h.startSession(w, user.ID, auth); err != nil {
+		log.Printf("Failed to create session: %v", err)
+		renderError(w, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	renderPage(w, http.StatusOK, "email_login.html", pageData{
+		Title:   "Sign in",
+		Message: "You are signed in and can return to the application.",
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"sso/internal/models"
+	"sso/pkg/token"
+)
+
+// Introspect implements OAuth 2.0 Token Introspection (RFC 7662) for access
+// and refresh tokens.
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.authenticateClient(r); err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	tokenString := r.PostFormValue("token")
+	if tokenString == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Missing token parameter")
+		return
+	}
+
+	response := models.IntrospectionResponse{Active: false}
+
+	// token_type_hint is optional and both token types are self-describing.
+	claims, err := h.tokenManager.Introspect(tokenString)
+	if err == nil {
+		tokenType, _ := claims["type"].(string)
+		response = models.IntrospectionResponse{
+			Active:    true,
+			TokenType: tokenType + "_token",
+		}
+		// Numeric claims decode as float64, formatting them with %v turns
+		// large user ids into exponents.
+		if userID, ok := token.UserID(claims); ok {
+			response.Sub = strconv.FormatUint(uint64(userID), 10)
+		} else {
+			response.Sub, _ = claims["sub"].(string)
+		}
+		if exp, ok := claims["exp"].(float64); ok {
+			response.Exp = int64(exp)
+		}
+		if iat, ok := claims["iat"].(float64); ok {
+			response.Iat = int64(iat)
+		}
+		response.Scope = token.Scope(claims)
+		if clientID, ok := claims["client_id"].(string); ok {
+			response.ClientID = clientID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
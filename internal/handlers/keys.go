@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"sso/pkg/token"
+)
+
+type KeyHandler struct {
+	keyRepo      token.KeyStore
+	tokenManager *token.JWTManager
+}
+
+func NewKeyHandler(keyRepo token.KeyStore, tokenManager *token.JWTManager) *KeyHandler {
+	return &KeyHandler{
+		keyRepo:      keyRepo,
+		tokenManager: tokenManager,
+	}
+}
+
+type rotateKeyRequest struct {
+	Algorithm string `json:"algorithm"`
+}
+
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keyRepo.ListKeys()
+	if err != nil {
+		http.Error(w, "Failed to retrieve keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RotateKey generates a new signing key and makes it active. The previous key
+// keeps verifying tokens for the lifetime of the longest refresh token.
+func (h *KeyHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	var req rotateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.tokenManager.Keys.Rotate(req.Algorithm, h.tokenManager.RefreshDuration)
+	if err != nil {
+		log.Printf("Failed to rotate signing key: %v", err)
+		http.Error(w, "Failed to rotate key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"kid": key.ID,
+		"alg": key.Algorithm,
+	})
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/mailer"
+	"sso/internal/middleware"
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+const (
+	loginBaseDelay = time.Second
+	loginMaxDelay  = time.Minute
+	unlockLinkTTL  = time.Hour
+)
+
+// LockoutPolicy configures how failed logins are throttled. After
+// BackoffAfter failures of an account within Window every further failure
+// doubles the delay before the next attempt. At the thresholds the account or
+// IP address is locked for LockDuration.
+type LockoutPolicy struct {
+	Window           time.Duration
+	BackoffAfter     int
+	AccountThreshold int
+	IPThreshold      int
+	LockDuration     time.Duration
+}
+
+// lockoutError refuses a login before the password is checked.
+type lockoutError struct {
+	reason     string
+	locked     bool
+	retryAfter time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return fmt.Sprintf("login refused: %s", e.reason)
+}
+
+// LoginGuard keeps per-account and per-IP failure counters.
+type LoginGuard struct {
+	repo   repository.LockoutRepository
+	policy LockoutPolicy
+}
+
+func NewLoginGuard(repo repository.LockoutRepository, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// accountKey identifies an account by its email whether it exists or not,
+// so throttling does not tell which emails are registered. The email is
+// hashed to keep it out of Redis.
+func accountKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "account:" + hex.EncodeToString(sum[:])
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a *lockoutError if logins to the account or from the IP
+// address are blocked. email is empty if the login does not name an account.
+// Redis errors let the login through.
+func (g *LoginGuard) Check(email, ip string) error {
+	if email != "" {
+		if err := g.check(accountKey(email), "account"); err != nil {
+			return err
+		}
+	}
+
+	return g.check(ipKey(ip), "ip")
+}
+
+func (g *LoginGuard) check(key, kind string) error {
+	block, err := g.repo.GetBlock(key)
+	if err != nil {
+		log.Printf("Failed to check login block: %v", err)
+		return nil
+	}
+	if block.RetryAfter <= 0 {
+		return nil
+	}
+
+	reason := kind + "_throttled"
+	if block.Locked {
+		reason = kind + "_locked"
+	}
+	return &lockoutError{reason: reason, locked: block.Locked, retryAfter: block.RetryAfter}
+}
+
+// Fail records a failed login and blocks further attempts. It reports whether
+// the account was locked by this failure. Unknown emails are counted like
+// accounts, so they are throttled the same. Addresses are only locked, not
+// slowed down, as many users may share one behind a NAT.
+func (g *LoginGuard) Fail(email, ip string) bool {
+	if _, err := g.fail(ipKey(ip), 0, g.policy.IPThreshold); err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+
+	if email == "" {
+		return false
+	}
+
+	locked, err := g.fail(accountKey(email), g.policy.BackoffAfter, g.policy.AccountThreshold)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+	return locked
+}
+
+// fail counts a failure of key. Once backoffAfter failures are reached the
+// key is throttled, at threshold it is locked. Zero disables either step.
+func (g *LoginGuard) fail(key string, backoffAfter, threshold int) (bool, error) {
+	count, err := g.repo.RecordFailure(key, g.policy.Window)
+	if err != nil {
+		return false, err
+	}
+
+	if threshold > 0 && count >= int64(threshold) {
+		return true, g.repo.Block(key, true, g.policy.LockDuration)
+	}
+
+	if excess := count - int64(backoffAfter); backoffAfter > 0 && excess >= 0 {
+		return false, g.repo.Block(key, false, backoffDelay(excess))
+	}
+
+	return false, nil
+}
+
+// backoffDelay doubles from loginBaseDelay up to loginMaxDelay.
+func backoffDelay(excess int64) time.Duration {
+	delay := float64(loginBaseDelay) * math.Pow(2, float64(excess))
+	if delay > float64(loginMaxDelay) {
+		return loginMaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// Succeed forgets the failures of the account.
+func (g *LoginGuard) Succeed(email string) {
+	if err := g.repo.Unlock(accountKey(email)); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+}
+
+func (g *LoginGuard) Unlock(email string) error {
+	return g.repo.Unlock(accountKey(email))
+}
+
+// LockedUntil returns when the account lock ends, or nil if it is not locked.
+func (g *LoginGuard) LockedUntil(email string) (*time.Time, error) {
+	block, err := g.repo.GetBlock(accountKey(email))
+	if err != nil || !block.Locked {
+		return nil, err
+	}
+
+	until := time.Now().Add(block.RetryAfter).Truncate(time.Second)
+	return &until, nil
+}
+
+// writeLockoutError answers a refused login with 429 and Retry-After.
+func writeLockoutError(w http.ResponseWriter, err *lockoutError) {
+	seconds := int(math.Ceil(err.retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, loginErrorMessage(err), http.StatusTooManyRequests)
+}
+
+func (h *AuthHandler) sendUnlockEmail(r *http.Request, user *models.User) error {
+	linkToken, err := h.tokenManager.GenerateLinkToken(token.PurposeAccountUnlock, user.ID, user.Email, unlockLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.tokenManager.Issuer + "/api/unlock?" + url.Values{"token": {linkToken}}.Encode()
+
+	msg, err := mailer.Render("account_locked", mailer.Locale(r.Header.Get("Accept-Language")), map[string]interface{}{
+		"Link":    link,
+		"Minutes": int(h.guard.policy.LockDuration.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.To = user.Email
+	return h.mailer.Send(msg)
+}
+
+// UnlockAccount consumes the link from the lockout email. Like email
+// verification, opening the link shows a confirmation button.
+func (h *AuthHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	if r.Method == http.MethodGet {
+		renderPage(w, http.StatusOK, "unlock.html", pageData{
+			Title:  "Unlock account",
+			Action: "/api/unlock",
+			Hidden: map[string]string{"token": r.URL.Query().Get("token")},
+		})
+		return
+	}
+
+	var linkToken string
+	if isJSON {
+		var req models.UnlockAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		linkToken = req.Token
+	} else {
+		linkToken = r.PostFormValue("token")
+	}
+
+	err := h.unlockAccount(r, linkToken)
+	if isJSON {
+		if err != nil {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid or expired link")
+		return
+	}
+	renderPage(w, http.StatusOK, "unlock.html", pageData{
+		Title:   "Unlock account",
+		Message: "Your account is unlocked. You can sign in again.",
+	})
+}
+
+func (h *AuthHandler) unlockAccount(r *http.Request, linkToken string) error {
+	claims, err := h.tokenManager.ConsumeLinkToken(linkToken, token.PurposeAccountUnlock)
+	if err != nil {
+		return err
+	}
+
+	userID, ok := token.UserID(claims)
+	if !ok {
+		return errInvalidCredentials
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil || user.Email != claims["email"] {
+		return errInvalidCredentials
+	}
+
+	if err := h.guard.Unlock(user.Email); err != nil {
+		return err
+	}
+
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventAccountUnlock,
+		Reason:    "unlocked_by_email",
+	})
+
+	return nil
+}
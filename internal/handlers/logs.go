@@ -4,33 +4,63 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"sso/internal/middleware"
+	"sso/internal/models"
 	"sso/internal/repository"
+	"sso/pkg/token"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 type LogHandler struct {
 	userRepo repository.UserRepository
 	logRepo  repository.LogRepository
+	roleRepo repository.RoleRepository
+	stepUp   middleware.StepUp
 }
 
-func NewLogHandler(userRepo repository.UserRepository, logRepo repository.LogRepository) *LogHandler {
+func NewLogHandler(userRepo repository.UserRepository, logRepo repository.LogRepository, roleRepo repository.RoleRepository, stepUp middleware.StepUp) *LogHandler {
 	return &LogHandler{
 		userRepo: userRepo,
 		logRepo:  logRepo,
+		roleRepo: roleRepo,
+		stepUp:   stepUp,
 	}
 }
 
 func (h *LogHandler) GetUserLogs(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(uint)
+	var logs []repository.LoginAttempt
+	var err error
 
-	user, err := h.userRepo.GetUserByID(userID)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// Service accounts are only let through with the logs:read scope and have
+	// no logs of their own, reading everyone's needs logs:read_all as well.
+	userID, isUser := r.Context().Value("user_id").(uint)
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+
+	if !isUser && !hasScope(token.Scope(claims), readAllLogsScope) {
+		http.Error(w, "Insufficient scope", http.StatusForbidden)
 		return
 	}
+	readAll := !isUser
 
-	var logs []repository.LoginAttempt
+	if isUser {
+		if _, err = h.userRepo.GetUserByID(userID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		readAll, err = h.roleRepo.HasPermission(userID, models.PermLogsReadAll)
+		if err != nil {
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+
+		// Everyone's logs need a recent sign in, without one the user's own
+		// are served.
+		readAll = readAll && h.stepUp.Satisfied(claims)
+	}
 
-	if user.Email == "admin" {
+	if readAll {
 		logs, err = h.logRepo.GetAllLogs()
 	} else {
 		logs, err = h.logRepo.GetUserLogs(userID)
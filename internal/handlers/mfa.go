@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"sso/internal/mailer"
+	"sso/internal/middleware"
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/internal/totp"
+	"sso/internal/webauthn"
+	"sso/pkg/token"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var (
+	errMFAChallengeExpired = errors.New("mfa challenge expired")
+	errMFAInvalidCode      = errors.New("invalid mfa code")
+	errMFACodeReused       = errors.New("mfa code already used")
+)
+
+// mfaRequired reports whether the user has a confirmed second factor.
+func (h *AuthHandler) mfaRequired(userID uint) (bool, error) {
+	methods, err := secondFactors(h.mfaRepo, userID)
+	return len(methods) > 0, err
+}
+
+// startMFA remembers a passed first factor for mfaChallengeTTL. scope is
+// what will be granted once the challenge is completed.
+func (h *AuthHandler) startMFA(userID uint, scope, method string) (*models.MFAChallengeResponse, error) {
+	methods, err := secondFactors(h.mfaRepo, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaToken, err := h.challengeRepo.CreateChallenge(&models.MFAChallenge{
+		UserID: userID,
+		Scope:  scope,
+		Method: method,
+	}, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		Methods:     append(methods, models.MFAMethodRecoveryCode),
+		ExpiresIn:   int64(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// completeMFA checks the second factor of a challenge and describes the
+// finished sign in. Wrong codes count as failed logins, and a challenge is
+// dropped after mfaMaxAttempts of them.
+func (h *AuthHandler) completeMFA(r *http.Request, req *models.MFALoginRequest) (*models.User, *models.MFAChallenge, token.Authentication, error) {
+	mfaToken := req.MFAToken
+	challenge, err := h.challengeRepo.GetChallenge(mfaToken)
+	if err != nil {
+		return nil, nil, token.Authentication{}, errMFAChallengeExpired
+	}
+
+	user, err := h.userRepo.GetUserByID(challenge.UserID)
+	if err != nil {
+		return nil, nil, token.Authentication{}, errMFAChallengeExpired
+	}
+
+	if err := h.checkLockout(r, user.ID, user.Email, challenge.Method); err != nil {
+		return nil, nil, token.Authentication{}, err
+	}
+
+	var factor string
+	if req.WebAuthn != nil {
+		factor, err = h.verifyPasskeyFactor(user.ID, req.WebAuthnSession, req.WebAuthn)
+	} else {
+		factor, err = verifySecondFactor(h.mfaRepo, user.ID, req.Code, req.RecoveryCode)
+	}
+	if errors.Is(err, errMFAInvalidCode) || errors.Is(err, errMFACodeReused) || passkeyRejected(err) {
+		reason := "mfa_invalid_code"
+		switch {
+		case errors.Is(err, errMFACodeReused):
+			reason = "mfa_code_reused"
+		case passkeyRejected(err):
+			reason = passkeyFailureReason(err)
+		}
+		h.failLogin(r, user, challenge.Method, reason)
+
+		attempts, failErr := h.challengeRepo.FailChallenge(mfaToken)
+		if failErr == nil && attempts >= mfaMaxAttempts {
+			h.challengeRepo.DeleteChallenge(mfaToken)
+		}
+
+		return nil, nil, token.Authentication{}, err
+	}
+	if err != nil {
+		return nil, nil, token.Authentication{}, err
+	}
+
+	// Completing a challenge twice, e.g. from two tabs, signs in only once.
+	if ok, err := h.challengeRepo.DeleteChallenge(mfaToken); err != nil || !ok {
+		return nil, nil, token.Authentication{}, errMFAChallengeExpired
+	}
+
+	// The account may have changed since the password was checked.
+	if err := h.checkAccount(r, user, challenge.Method); err != nil {
+		return nil, nil, token.Authentication{}, err
+	}
+
+	h.guard.Succeed(user.Email)
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Method:    challenge.Method,
+		Reason:    "mfa_" + factor,
+	})
+
+	return user, challenge, loginAuthentication(challenge.Method, factor), nil
+}
+
+// verifySecondFactor accepts a TOTP code or, if given, a recovery code and
+// returns the method used. Each code is accepted only once.
+func verifySecondFactor(mfaRepo repository.MFARepository, userID uint, code, recoveryCode string) (string, error) {
+	if recoveryCode != "" {
+		ok, err := mfaRepo.UseRecoveryCode(userID, recoveryCode)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errMFAInvalidCode
+		}
+		return models.MFAMethodRecoveryCode, nil
+	}
+
+	credential, err := mfaRepo.GetTOTP(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !credential.Confirmed) {
+		return "", errMFAInvalidCode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	step, ok := totp.Validate(credential.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return "", errMFAInvalidCode
+	}
+
+	fresh, err := mfaRepo.UseTOTPStep(userID, step)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", errMFACodeReused
+	}
+
+	return models.MFAMethodTOTP, nil
+}
+
+// verifyPasskeyFactor accepts an assertion of one of the user's passkeys.
+func (h *AuthHandler) verifyPasskeyFactor(userID uint, sessionID string, resp *webauthn.AssertionResponse) (string, error) {
+	credential, err := h.verifyPasskey(sessionID, models.WebAuthnMFA, resp)
+	if credential != nil && credential.UserID != userID {
+		return "", errPasskeyRejected
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return models.MFAMethodWebAuthn, nil
+}
+
+// LoginMFA completes the challenge returned by Login and issues the tokens.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, challenge, auth, err := h.completeMFA(r, &req)
+	var lockoutErr *lockoutError
+	if errors.As(err, &lockoutErr) {
+		writeLockoutError(w, lockoutErr)
+		return
+	}
+	if errors.Is(err, errAccountDisabled) || errors.Is(err, errPasswordResetRequired) || errors.Is(err, errEmailNotVerified) {
+		http.Error(w, loginErrorMessage(err), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, loginErrorMessage(err), http.StatusUnauthorized)
+		return
+	}
+
+	tokenResp, err := h.tokenManager.Generate(user.ID, challenge.Scope, auth)
+	if err != nil {
+		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResp)
+}
+
+// MFAHandler lets users manage their second factors.
+type MFAHandler struct {
+	userRepo         repository.UserRepository
+	logRepo          repository.LogRepository
+	mfaRepo          repository.MFARepository
+	webauthnSessions repository.WebAuthnSessionRepository
+	relyingParty     *webauthn.RelyingParty
+	mailer           mailer.Mailer
+	guard            *LoginGuard
+	totpIssuer       string
+}
+
+func NewMFAHandler(
+	userRepo repository.UserRepository,
+	logRepo repository.LogRepository,
+	mfaRepo repository.MFARepository,
+	webauthnSessions repository.WebAuthnSessionRepository,
+	relyingParty *webauthn.RelyingParty,
+	mailer mailer.Mailer,
+	guard *LoginGuard,
+	totpIssuer string,
+) *MFAHandler {
+	return &MFAHandler{
+		userRepo:         userRepo,
+		logRepo:          logRepo,
+		mfaRepo:          mfaRepo,
+		webauthnSessions: webauthnSessions,
+		relyingParty:     relyingParty,
+		mailer:           mailer,
+		guard:            guard,
+		totpIssuer:       totpIssuer,
+	}
+}
+
+func (h *MFAHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var status models.MFAStatus
+
+	credential, err := h.mfaRepo.GetTOTP(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Failed to retrieve second factors", http.StatusInternalServerError)
+		return
+	}
+	status.TOTP = err == nil && credential.Confirmed
+
+	passkeys, err := h.mfaRepo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve second factors", http.StatusInternalServerError)
+		return
+	}
+	status.Passkeys = len(passkeys)
+
+	left, err := h.mfaRepo.CountRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve second factors", http.StatusInternalServerError)
+		return
+	}
+	status.RecoveryCodesLeft = int(left)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollTOTP creates a new secret for an authenticator app. It only counts as
+// a second factor once ConfirmTOTP gets a code generated from it.
+func (h *MFAHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	credential, err := h.mfaRepo.GetTOTP(userID)
+	if err == nil && credential.Confirmed {
+		http.Error(w, "An authenticator app is already set up", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to set up the authenticator app", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.mfaRepo.SaveTOTP(&models.TOTPCredential{UserID: userID, Secret: secret}); err != nil {
+		log.Printf("Failed to store TOTP secret of user %d: %v", userID, err)
+		http.Error(w, "Failed to set up the authenticator app", http.StatusInternalServerError)
+		return
+	}
+
+	uri := totp.URI(h.totpIssuer, user.Email, secret)
+	png, err := totp.QRCode(uri)
+	if err != nil {
+		http.Error(w, "Failed to set up the authenticator app", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.TOTPEnrollmentResponse{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// ConfirmTOTP enables the authenticator app and returns the recovery codes,
+// which are shown only this once.
+func (h *MFAHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := h.mfaRepo.GetTOTP(userID)
+	if err != nil || credential.Confirmed {
+		http.Error(w, "No authenticator app is being set up", http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(credential.Secret, req.Code, time.Now(), totpSkew)
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.mfaRepo.ConfirmTOTP(userID, step); err != nil {
+		http.Error(w, "No authenticator app is being set up", http.StatusConflict)
+		return
+	}
+
+	codes, err := h.mfaRepo.ReplaceRecoveryCodes(userID, recoveryCodeCount)
+	if err != nil {
+		log.Printf("Failed to create recovery codes of user %d: %v", userID, err)
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	h.logChange(r, userID, "mfa_enabled")
+	h.notifyFactorAdded(r, userID, models.MFAMethodTOTP, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP removes the authenticator app and the recovery codes. It takes
+// a current code, so a stolen access token cannot turn the second factor off.
+func (h *MFAHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	if !h.verify(w, r, userID) {
+		return
+	}
+
+	if err := h.mfaRepo.DeleteTOTP(userID); err != nil {
+		http.Error(w, "Failed to remove the authenticator app", http.StatusInternalServerError)
+		return
+	}
+
+	h.logChange(r, userID, "mfa_disabled")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, e.g. when most are used.
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	if !h.verify(w, r, userID) {
+		return
+	}
+
+	codes, err := h.mfaRepo.ReplaceRecoveryCodes(userID, recoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verify checks the code or recovery code in the request body. Wrong codes
+// count as failed logins of the account, so a stolen access token cannot be
+// used to guess them.
+func (h *MFAHandler) verify(w http.ResponseWriter, r *http.Request, userID uint) bool {
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return false
+	}
+
+	if err := h.guard.Check(user.Email, middleware.ClientIP(r)); err != nil {
+		writeLockoutError(w, err.(*lockoutError))
+		return false
+	}
+
+	_, err = verifySecondFactor(h.mfaRepo, userID, req.Code, req.RecoveryCode)
+	if errors.Is(err, errMFAInvalidCode) || errors.Is(err, errMFACodeReused) {
+		h.guard.Fail(user.Email, middleware.ClientIP(r))
+		h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Success:   false,
+			IP:        middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			Event:     repository.EventMFAChange,
+			Reason:    "mfa_invalid_code",
+		})
+
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to check the code", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// notifyFactorAdded tells the user about a new second factor, in case
+// someone else added it to keep access to the account.
+func (h *MFAHandler) notifyFactorAdded(r *http.Request, userID uint, method, name string) {
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return
+	}
+
+	msg, err := mailer.Render("factor_added", mailer.Locale(r.Header.Get("Accept-Language")), map[string]interface{}{
+		"Method": method,
+		"Name":   name,
+	})
+	if err != nil {
+		log.Printf("Failed to render second factor email: %v", err)
+		return
+	}
+
+	msg.To = user.Email
+	if err := h.mailer.Send(msg); err != nil {
+		log.Printf("Failed to send second factor email to user %d: %v", user.ID, err)
+	}
+}
+
+func (h *MFAHandler) logChange(r *http.Request, userID uint, reason string) {
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return
+	}
+
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventMFAChange,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+var errInvalidClient = errors.New("invalid client")
+
+type OAuthHandler struct {
+	clientRepo      repository.ClientRepository
+	userRepo        repository.UserRepository
+	sessionRepo     repository.SessionRepository
+	codeRepo        repository.AuthCodeRepository
+	deviceRepo      repository.DeviceCodeRepository
+	authHandler     *AuthHandler
+	tokenManager    *token.JWTManager
+	sessionDuration time.Duration
+}
+
+func NewOAuthHandler(
+	clientRepo repository.ClientRepository,
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	codeRepo repository.AuthCodeRepository,
+	deviceRepo repository.DeviceCodeRepository,
+	authHandler *AuthHandler,
+	tokenManager *token.JWTManager,
+	sessionDuration time.Duration,
+) *OAuthHandler {
+	return &OAuthHandler{
+		clientRepo:      clientRepo,
+		userRepo:        userRepo,
+		sessionRepo:     sessionRepo,
+		codeRepo:        codeRepo,
+		deviceRepo:      deviceRepo,
+		authHandler:     authHandler,
+		tokenManager:    tokenManager,
+		sessionDuration: sessionDuration,
+	}
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oauthError{Error: code, ErrorDescription: description})
+}
+
+// AuthenticateClient checks the credentials of confidential clients before
+// the endpoint runs, so the middleware after it can count requests per
+// client. Wrong credentials are rejected, requests without any pass on to be
+// handled as public clients.
+func (h *OAuthHandler) AuthenticateClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+			return
+		}
+
+		if !hasClientCredentials(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, err := h.authenticateClient(r)
+		if err != nil {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "client", client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func hasClientCredentials(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return ok || r.PostFormValue("client_secret") != ""
+}
+
+// authenticateClient checks client credentials sent with HTTP Basic auth or
+// as client_id/client_secret form parameters, unless AuthenticateClient
+// already did.
+func (h *OAuthHandler) authenticateClient(r *http.Request) (*models.OAuthClient, error) {
+	if client, ok := r.Context().Value("client").(*models.OAuthClient); ok {
+		return client, nil
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := h.clientRepo.GetClientByClientID(clientID)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+
+	if !client.Confidential() {
+		return nil, errInvalidClient
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)); err != nil {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"sso/internal/mailer"
+	"sso/internal/middleware"
+	"sso/internal/models"
+	"sso/internal/password"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordHandler lets users change their password or recover access to their
+// account.
+type PasswordHandler struct {
+	userRepo     repository.UserRepository
+	logRepo      repository.LogRepository
+	resetRepo    repository.PasswordResetRepository
+	sessionRepo  repository.SessionRepository
+	tokenManager *token.JWTManager
+	mailer       mailer.Mailer
+	policy       *password.Policy
+	hasher       *password.Hasher
+	guard        *LoginGuard
+}
+
+func NewPasswordHandler(
+	userRepo repository.UserRepository,
+	logRepo repository.LogRepository,
+	resetRepo repository.PasswordResetRepository,
+	sessionRepo repository.SessionRepository,
+	tokenManager *token.JWTManager,
+	mailer mailer.Mailer,
+	policy *password.Policy,
+	hasher *password.Hasher,
+	guard *LoginGuard,
+) *PasswordHandler {
+	return &PasswordHandler{
+		userRepo:     userRepo,
+		logRepo:      logRepo,
+		resetRepo:    resetRepo,
+		sessionRepo:  sessionRepo,
+		tokenManager: tokenManager,
+		mailer:       mailer,
+		policy:       policy,
+		hasher:       hasher,
+		guard:        guard,
+	}
+}
+
+// ForgotPassword emails a reset link. It always responds with 202 and sends
+// after answering, so neither the answer nor its timing reveals which
+// addresses are registered.
+func (h *PasswordHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	locale := mailer.Locale(r.Header.Get("Accept-Language"))
+	go func() {
+		user, err := h.userRepo.GetUserByEmail(req.Email)
+		if err != nil || user.Disabled {
+			return
+		}
+		if err := h.sendResetEmail(locale, user); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *PasswordHandler) sendResetEmail(locale string, user *models.User) error {
+	resetToken, err := h.resetRepo.CreateResetToken(user.ID, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.tokenManager.Issuer + "/api/password/reset?" + url.Values{"token": {resetToken}}.Encode()
+
+	msg, err := mailer.Render("password_reset", locale, map[string]interface{}{
+		"Link":    link,
+		"Minutes": int(passwordResetTTL.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.To = user.Email
+	return h.mailer.Send(msg)
+}
+
+// ResetPassword sets a new password with a token from the reset email.
+// Opening the link shows a form, JSON clients POST the token and password.
+func (h *PasswordHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	if r.Method == http.MethodGet {
+		renderPage(w, http.StatusOK, "reset_password.html", pageData{
+			Title:  "Reset password",
+			Action: "/api/password/reset",
+			Hidden: map[string]string{"token": r.URL.Query().Get("token")},
+		})
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if isJSON {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Token = r.PostFormValue("token")
+		req.Password = r.PostFormValue("password")
+	}
+
+	warnings, err := h.resetPassword(r, req)
+	var policyErr *password.PolicyError
+	if isJSON {
+		switch {
+		case errors.As(err, &policyErr):
+			writePasswordError(w, err)
+		case errors.Is(err, errInvalidResetToken):
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		case len(warnings) > 0:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"warnings": warnings,
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	switch {
+	case errors.As(err, &policyErr):
+		renderPage(w, http.StatusBadRequest, "reset_password.html", pageData{
+			Title:  "Reset password",
+			Action: "/api/password/reset",
+			Error:  policyErr.Error(),
+			Hidden: map[string]string{"token": req.Token},
+		})
+	case errors.Is(err, errInvalidResetToken):
+		renderError(w, http.StatusBadRequest, "Invalid or expired link")
+	case err != nil:
+		renderError(w, http.StatusInternalServerError, "Failed to reset password")
+	default:
+		renderPage(w, http.StatusOK, "reset_password.html", pageData{
+			Title:    "Reset password",
+			Message:  "Your password was changed. You can sign in with the new password.",
+			Warnings: warnings,
+		})
+	}
+}
+
+// resetPassword checks the new password before the token is used up, so a
+// rejected password can be corrected with the same link.
+func (h *PasswordHandler) resetPassword(r *http.Request, req models.ResetPasswordRequest) ([]string, error) {
+	if err := h.policy.Check(req.Password); err != nil {
+		return nil, err
+	}
+
+	userID, err := h.resetRepo.ConsumeResetToken(req.Token)
+	if err != nil {
+		return nil, errInvalidResetToken
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, errInvalidResetToken
+	}
+
+	if err := h.userRepo.UpdatePassword(user.ID, req.Password); err != nil {
+		log.Printf("Failed to update password of user %d: %v", user.ID, err)
+		return nil, err
+	}
+
+	warnings := screenBreach(h.policy, h.userRepo, user.ID, req.Password, true)
+
+	// The reset link was delivered to the address, which proves it.
+	if !user.EmailVerified {
+		if err := h.userRepo.SetEmailVerified(user.ID, true); err != nil {
+			log.Printf("Failed to verify email of user %d: %v", user.ID, err)
+		}
+	}
+
+	if err := h.signOut(user.ID); err != nil {
+		return nil, err
+	}
+
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventPasswordReset,
+	})
+
+	return warnings, nil
+}
+
+// ChangePassword sets a new password for the signed in user. Every other
+// token and session is revoked, the caller gets a new token pair.
+func (h *PasswordHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// The current password is guessed against the same budget as signing in.
+	if err := h.guard.Check(user.Email, middleware.ClientIP(r)); err != nil {
+		writeLockoutError(w, err.(*lockoutError))
+		return
+	}
+
+	ok, err := h.hasher.Verify(req.CurrentPassword, user.Password)
+	if err != nil {
+		log.Printf("Failed to verify password of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.guard.Fail(user.Email, middleware.ClientIP(r))
+		h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Success:   false,
+			IP:        middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			Event:     repository.EventPasswordChange,
+			Reason:    "invalid_password",
+		})
+
+		http.Error(w, "Invalid current password", http.StatusForbidden)
+		return
+	}
+
+	if err := h.policy.Check(req.NewPassword); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		writePasswordError(w, &password.PolicyError{Violations: []password.Violation{{
+			Rule:    "unchanged",
+			Message: "New password must differ from the current one",
+		}}})
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(user.ID, req.NewPassword); err != nil {
+		log.Printf("Failed to update password of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	warnings := screenBreach(h.policy, h.userRepo, user.ID, req.NewPassword, false)
+
+	if err := h.signOut(user.ID); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	h.guard.Succeed(user.Email)
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventPasswordChange,
+	})
+
+	opts := token.Options{UserID: user.ID}
+	opts.ClientID, _ = claims["client_id"].(string)
+	opts.Scope = token.Scope(claims)
+	opts.Auth = token.AuthenticationFromClaims(claims)
+
+	tokenResp, err := h.tokenManager.Issue(opts)
+	if err != nil {
+		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+	tokenResp.Warnings = warnings
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResp)
+}
+
+// signOut revokes every token and SSO session of the user, so whoever knew
+// the old password does not stay signed in.
+func (h *PasswordHandler) signOut(userID uint) error {
+	if err := h.tokenManager.RevokeUser(userID); err != nil {
+		log.Printf("Failed to revoke tokens of user %d: %v", userID, err)
+		return err
+	}
+	if err := h.sessionRepo.DeleteUserSessions(userID); err != nil {
+		log.Printf("Failed to delete sessions of user %d: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+// screenBreach applies the warn and flag breach actions to a password that
+// was just set, and returns the warnings for the response. Flagged accounts
+// must reset their password before signing in again. A reset itself only
+// warns, flagging it would demand the reset that was just made.
+func screenBreach(policy *password.Policy, userRepo repository.UserRepository, userID uint, pw string, reset bool) []string {
+	action := policy.BreachAction(pw)
+	if reset && action == password.BreachFlag {
+		action = password.BreachWarn
+	}
+
+	switch action {
+	case password.BreachWarn:
+		return []string{"This password appears in a known data breach, consider changing it."}
+	case password.BreachFlag:
+		if err := userRepo.SetPasswordResetRequired(userID, true); err != nil {
+			log.Printf("Failed to flag user %d for a password reset: %v", userID, err)
+		}
+		return []string{"This password appears in a known data breach, reset it before signing in again."}
+	}
+	return nil
+}
+
+// writePasswordError lists the violated policy rules so clients can show
+// them next to the password field.
+func writePasswordError(w http.ResponseWriter, err error) {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		http.Error(w, "Invalid password", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "Password does not meet the policy",
+		"violations": policyErr.Violations,
+	})
+}
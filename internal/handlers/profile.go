@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"github.com/golang-jwt/jwt/v4"
+
 	"sso/internal/repository"
+	"sso/pkg/token"
 )
 
 type ProfileHandler struct {
@@ -29,3 +33,32 @@ func (h *ProfileHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// UserInfo is the OpenID Connect userinfo endpoint. Claims are released
+// according to the scopes of the access token, which must include openid.
+func (h *ProfileHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	claims := r.Context().Value("claims").(jwt.MapClaims)
+	scope := token.Scope(claims)
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	info := map[string]interface{}{
+		"sub": fmt.Sprintf("%d", user.ID),
+	}
+	if hasScope(scope, "email") {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerified
+	}
+	if hasScope(scope, "profile") {
+		info["updated_at"] = user.UpdateAt.Unix()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(info)
+}
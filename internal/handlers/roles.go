@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+)
+
+// RoleHandler is the admin API for roles and role grants.
+type RoleHandler struct {
+	roleRepo repository.RoleRepository
+	userRepo repository.UserRepository
+}
+
+func NewRoleHandler(roleRepo repository.RoleRepository, userRepo repository.UserRepository) *RoleHandler {
+	return &RoleHandler{
+		roleRepo: roleRepo,
+		userRepo: userRepo,
+	}
+}
+
+func (h *RoleHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roleRepo.ListRoles()
+	if err != nil {
+		http.Error(w, "Failed to retrieve roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// SaveRole creates a role or replaces its permissions.
+func (h *RoleHandler) SaveRole(w http.ResponseWriter, r *http.Request) {
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, permission := range req.Permissions {
+		if !contains(models.Permissions, permission) {
+			http.Error(w, fmt.Sprintf("unknown permission %q", permission), http.StatusBadRequest)
+			return
+		}
+	}
+
+	role := &models.Role{
+		Name:        mux.Vars(r)["name"],
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+
+	if err := h.roleRepo.SaveRole(role); err != nil {
+		http.Error(w, "Failed to save role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *RoleHandler) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	roles, err := h.roleRepo.GetUserRoles(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve roles", http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := h.roleRepo.GetUserPermissions(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"roles":       roles,
+		"permissions": permissions,
+	})
+}
+
+func (h *RoleHandler) GrantRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	var req models.GrantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.roleRepo.GrantRole(user.ID, req.Role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to grant role", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RoleHandler) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	err := h.roleRepo.RevokeRole(user.ID, mux.Vars(r)["role"])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Role not granted", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke role", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import "strings"
+
+const (
+	// adminScope is needed for the admin API. Only users holding at least one
+	// permission may get it.
+	adminScope = "admin"
+	// readAllLogsScope lets a service account read the sign in history of
+	// every user. It is only granted to clients registered with it.
+	readAllLogsScope = "logs:read_all"
+)
+
+var (
+	// userScopes may be granted to tokens issued on behalf of any user.
+	userScopes = []string{"openid", "profile", "email", "offline_access", "logs:read"}
+	// defaultScopes are granted at login when no scope is requested.
+	defaultScopes = []string{"openid", "profile", "email"}
+	// unverifiedScopes are granted to users who have not verified their
+	// email address under the "restrict" policy. Their own sign in history
+	// stays readable.
+	unverifiedScopes = []string{"openid", "profile", "email", "logs:read"}
+	// serviceScopes are granted to tokens from the client credentials grant.
+	serviceScopes = []string{"logs:read", readAllLogsScope}
+	// loginScopes may be requested at login, depending on the user.
+	loginScopes = append(append([]string{}, userScopes...), adminScope)
+
+	supportedScopes = append(append([]string{}, loginScopes...), readAllLogsScope)
+)
+
+// grantScopes keeps the requested scopes that are supported and allowed,
+// dropping duplicates from the space-separated list.
+func grantScopes(requested string, allowed []string) string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if contains(supportedScopes, scope) && contains(allowed, scope) && !contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " ")
+}
+
+func hasScope(scope, want string) bool {
+	return contains(strings.Fields(scope), want)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(a, b []string) []string {
+	var result []string
+	for _, item := range a {
+		if contains(b, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
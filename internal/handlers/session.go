@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/internal/webauthn"
+	"sso/pkg/token"
+)
+
+const sessionCookieName = "sso_session"
+
+// currentSession returns the hosted login session of the browser, if any.
+func (h *OAuthHandler) currentSession(r *http.Request) *models.Session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	session, err := h.sessionRepo.GetSession(cookie.Value)
+	if err != nil {
+		return nil
+	}
+
+	return session
+}
+
+// signIn checks a posted hosted login form and describes the sign in. Users
+// with a second factor get the form again, asking for a code or a passkey,
+// and submit it with the mfa_token. A passkey assertion, posted as JSON in
+// the webauthn field, also signs in without a password. When nil is returned
+// the next page is rendered with render.
+func (h *OAuthHandler) signIn(w http.ResponseWriter, r *http.Request, render loginRenderer) (*models.User, token.Authentication) {
+	var assertion *webauthn.AssertionResponse
+	if value := r.PostFormValue("webauthn"); value != "" {
+		assertion = &webauthn.AssertionResponse{}
+		if err := json.Unmarshal([]byte(value), assertion); err != nil {
+			render(http.StatusBadRequest, "", loginErrorMessage(errPasskeyRejected), r.PostFormValue("mfa_token"))
+			return nil, token.Authentication{}
+		}
+	}
+
+	if mfaToken := r.PostFormValue("mfa_token"); mfaToken != "" {
+		user, _, auth, err := h.authHandler.completeMFA(r, &models.MFALoginRequest{
+			MFAToken:        mfaToken,
+			Code:            r.PostFormValue("code"),
+			RecoveryCode:    r.PostFormValue("recovery_code"),
+			WebAuthnSession: r.PostFormValue("webauthn_session"),
+			WebAuthn:        assertion,
+		})
+		if errors.Is(err, errMFAInvalidCode) || errors.Is(err, errMFACodeReused) || passkeyRejected(err) {
+			render(http.StatusUnauthorized, "", loginErrorMessage(err), mfaToken)
+			return nil, token.Authentication{}
+		}
+		if err != nil {
+			render(http.StatusUnauthorized, "", loginErrorMessage(err), "")
+			return nil, token.Authentication{}
+		}
+		return user, auth
+	}
+
+	if assertion != nil {
+		user, err := h.authHandler.authenticatePasskey(r, r.PostFormValue("webauthn_session"), assertion)
+		if err != nil {
+			render(http.StatusUnauthorized, "", loginErrorMessage(err), "")
+			return nil, token.Authentication{}
+		}
+		return user, loginAuthentication(repository.LoginMethodWebAuthn, "")
+	}
+
+	email := r.PostFormValue("email")
+
+	user, mfa, err := h.authHandler.authenticate(r, email, r.PostFormValue("password"))
+	if err != nil {
+		render(http.StatusUnauthorized, email, loginErrorMessage(err), "")
+		return nil, token.Authentication{}
+	}
+
+	if mfa {
+		challenge, err := h.authHandler.startMFA(user.ID, "", repository.LoginMethodPassword)
+		if err != nil {
+			log.Printf("Failed to create MFA challenge: %v", err)
+			renderError(w, http.StatusInternalServerError, "Failed to sign in")
+			return nil, token.Authentication{}
+		}
+		render(http.StatusOK, email, "", challenge.MFAToken)
+		return nil, token.Authentication{}
+	}
+
+	return user, loginAuthentication(repository.LoginMethodPassword, "")
+}
+
+// loginRenderer shows the hosted login form, asking for a code if mfaToken
+// is set.
+type loginRenderer func(status int, email, message, mfaToken string)
+
+func (h *OAuthHandler) startSession(w http.ResponseWriter, userID uint, auth token.Authentication) (*models.Session, error) {
+	session := &models.Session{
+		UserID:   userID,
+		AuthTime: auth.Time,
+		AMR:      auth.Methods,
+	}
+
+	if err := h.sessionRepo.CreateSession(session, h.sessionDuration); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		MaxAge:   int(h.sessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.tokenManager.Issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return session, nil
+}
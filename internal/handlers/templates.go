@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// pageData is shared by the hosted pages.
+type pageData struct {
+	Title      string
+	Action     string
+	ClientName string
+	Email      string
+	Error      string
+	Message    string
+	Warnings   []string
+	MFA        bool
+	UserCode   string
+	Scopes     []string
+	Hidden     map[string]string
+}
+
+func renderPage(w http.ResponseWriter, status int, name string, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Failed to render %s: %v", name, err)
+	}
+}
+
+func renderError(w http.ResponseWriter, status int, message string) {
+	renderPage(w, status, "error.html", pageData{Title: "Error", Error: message})
+}
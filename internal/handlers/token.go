@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sso/internal/models"
+	"sso/pkg/token"
+)
+
+// Token is the OAuth 2.0 token endpoint.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		h.authorizationCodeGrant(w, r)
+	case "refresh_token":
+		h.refreshTokenGrant(w, r)
+	case "client_credentials":
+		h.clientCredentialsGrant(w, r)
+	case models.GrantDeviceCode:
+		h.deviceCodeGrant(w, r)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// tokenClient authenticates confidential clients and identifies public
+// clients by client_id alone.
+func (h *OAuthHandler) tokenClient(r *http.Request) (*models.OAuthClient, error) {
+	if hasClientCredentials(r) {
+		return h.authenticateClient(r)
+	}
+
+	client, err := h.clientRepo.GetClientByClientID(r.PostFormValue("client_id"))
+	if err != nil || client.Confidential() {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+func (h *OAuthHandler) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.tokenClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	code, err := h.codeRepo.ConsumeCode(r.PostFormValue("code"))
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+		return
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != r.PostFormValue("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client")
+		return
+	}
+
+	if !token.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	h.issueUserTokens(w, client, code.UserID, code.Scope, code.Nonce, token.Authentication{Time: code.AuthTime, Methods: code.AMR})
+}
+
+// issueUserTokens writes the token response of a grant that a user approved
+// after signing in as described by auth.
+func (h *OAuthHandler) issueUserTokens(w http.ResponseWriter, client *models.OAuthClient, userID uint, scope, nonce string, auth token.Authentication) {
+	opts := token.Options{
+		UserID:    userID,
+		ClientID:  client.ClientID,
+		Scope:     scope,
+		Auth:      auth,
+		Lifetimes: clientLifetimes(client),
+	}
+
+	tokenResp, err := h.tokenManager.Issue(opts)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	response := models.OAuthTokenResponse{
+		AccessToken: tokenResp.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(tokenResp.ExpiresAt).Seconds()),
+		Scope:       opts.Scope,
+	}
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		response.RefreshToken = tokenResp.RefreshToken
+	}
+
+	if hasScope(opts.Scope, "openid") {
+		response.IDToken, err = h.generateIDToken(opts, nonce)
+		if err != nil {
+			log.Printf("Failed to generate ID token: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+	}
+
+	writeTokenResponse(w, response)
+}
+
+func (h *OAuthHandler) generateIDToken(opts token.Options, nonce string) (string, error) {
+	user, err := h.userRepo.GetUserByID(opts.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	claims := map[string]interface{}{}
+	if hasScope(opts.Scope, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+
+	return h.tokenManager.GenerateIDToken(user.ID, opts.ClientID, nonce, opts.Auth, claims)
+}
+
+func (h *OAuthHandler) refreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.tokenClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantRefreshToken) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	if err := h.authHandler.checkRefreshUser(r.PostFormValue("refresh_token")); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", loginErrorMessage(err))
+		return
+	}
+
+	tokenResp, opts, err := h.tokenManager.Refresh(r.PostFormValue("refresh_token"), client.ClientID, clientLifetimes(client))
+	if errors.Is(err, token.ErrRefreshTokenReused) {
+		h.authHandler.logRefreshReuse(r, opts.UserID)
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+
+	writeTokenResponse(w, models.OAuthTokenResponse{
+		AccessToken:  tokenResp.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(tokenResp.ExpiresAt).Seconds()),
+		RefreshToken: tokenResp.RefreshToken,
+		Scope:        opts.Scope,
+	})
+}
+
+// clientCredentialsGrant issues a token whose subject is the client itself,
+// limited to service scopes.
+func (h *OAuthHandler) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantClientCredentials) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	allowed := intersect(client.Scopes, serviceScopes)
+
+	requested := r.PostFormValue("scope")
+	if requested == "" {
+		requested = strings.Join(allowed, " ")
+	}
+
+	scope := grantScopes(requested, allowed)
+	if scope == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "")
+		return
+	}
+
+	tokenResp, err := h.tokenManager.IssueClientToken(client.ClientID, scope, clientLifetimes(client))
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	writeTokenResponse(w, models.OAuthTokenResponse{
+		AccessToken: tokenResp.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(tokenResp.ExpiresAt).Seconds()),
+		Scope:       scope,
+	})
+}
+
+func clientLifetimes(client *models.OAuthClient) token.Lifetimes {
+	return token.Lifetimes{
+		Access:  time.Duration(client.AccessTokenTTL) * time.Second,
+		Refresh: time.Duration(client.RefreshTokenTTL) * time.Second,
+	}
+}
+
+func writeTokenResponse(w http.ResponseWriter, response models.OAuthTokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(response)
+}
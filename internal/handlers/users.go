@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"sso/internal/middleware"
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/pkg/token"
+)
+
+const (
+	defaultUsersPerPage = 20
+	maxUsersPerPage     = 100
+)
+
+// UserHandler is the admin API for user accounts.
+type UserHandler struct {
+	userRepo     repository.UserRepository
+	logRepo      repository.LogRepository
+	roleRepo     repository.RoleRepository
+	mfaRepo      repository.MFARepository
+	sessionRepo  repository.SessionRepository
+	tokenManager *token.JWTManager
+	guard        *LoginGuard
+}
+
+func NewUserHandler(
+	userRepo repository.UserRepository,
+	logRepo repository.LogRepository,
+	roleRepo repository.RoleRepository,
+	mfaRepo repository.MFARepository,
+	sessionRepo repository.SessionRepository,
+	tokenManager *token.JWTManager,
+	guard *LoginGuard,
+) *UserHandler {
+	return &UserHandler{
+		userRepo:     userRepo,
+		logRepo:      logRepo,
+		roleRepo:     roleRepo,
+		mfaRepo:      mfaRepo,
+		sessionRepo:  sessionRepo,
+		tokenManager: tokenManager,
+		guard:        guard,
+	}
+}
+
+// ListUsers returns a page of users, optionally filtered by a substring of
+// the email address (?email=, ?page=, ?per_page=).
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultUsersPerPage
+	}
+	if perPage > maxUsersPerPage {
+		perPage = maxUsersPerPage
+	}
+
+	users, total, err := h.userRepo.ListUsers(r.URL.Query().Get("email"), (page-1)*perPage, perPage)
+	if err != nil {
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+
+	if users == nil {
+		users = []models.User{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.UserList{
+		Users:   users,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	roles, err := h.roleRepo.GetUserRoles(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve roles", http.StatusInternalServerError)
+		return
+	}
+
+	factors, err := secondFactors(h.mfaRepo, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve second factors", http.StatusInternalServerError)
+		return
+	}
+
+	lockedUntil, err := h.guard.LockedUntil(user.Email)
+	if err != nil {
+		log.Printf("Failed to check lockout of user %d: %v", user.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.UserDetails{
+		User:        user,
+		Roles:       roles,
+		MFAEnabled:  len(factors) > 0,
+		LockedUntil: lockedUntil,
+	})
+}
+
+// DisableUser blocks sign in and ends all sessions of the user.
+func (h *UserHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.otherUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userRepo.SetDisabled(user.ID, true); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.signOut(w, user.ID) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	if err := h.userRepo.SetDisabled(user.ID, false); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnlockUser lifts a lockout after failed logins and forgets the failures.
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	if err := h.guard.Unlock(user.Email); err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventAccountUnlock,
+		Reason:    "unlocked_by_admin",
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetMFA removes the second factors of a user who lost them. The user
+// signs in with the password only until a new one is set up.
+func (h *UserHandler) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.otherUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.mfaRepo.DeleteFactors(user.ID); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Event:     repository.EventMFAChange,
+		Reason:    "mfa_reset_by_admin",
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequirePasswordReset ends all sessions of the user, who cannot sign in
+// again until the password is reset.
+func (h *UserHandler) RequirePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	if err := h.userRepo.SetPasswordResetRequired(user.ID, true); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.signOut(w, user.ID) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions revokes every token of the user and ends their hosted login
+// sessions.
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	if !h.signOut(w, user.ID) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.otherUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Signing out first leaves no usable token behind should deleting fail.
+	if !h.signOut(w, user.ID) {
+		return
+	}
+
+	err := h.userRepo.DeleteUser(user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// otherUser is like userFromPath but refuses to act on the calling admin, who
+// would otherwise lock themselves out.
+func (h *UserHandler) otherUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := userFromPath(w, r, h.userRepo)
+	if !ok {
+		return nil, false
+	}
+
+	if user.ID == r.Context().Value("user_id").(uint) {
+		http.Error(w, "Cannot apply this to your own account", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (h *UserHandler) signOut(w http.ResponseWriter, userID uint) bool {
+	if err := h.tokenManager.RevokeUser(userID); err != nil {
+		log.Printf("Failed to revoke tokens of user %d: %v", userID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return false
+	}
+
+	if err := h.sessionRepo.DeleteUserSessions(userID); err != nil {
+		log.Printf("Failed to delete sessions of user %d: %v", userID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// userFromPath loads the user named by the {id} route variable.
+func userFromPath(w http.ResponseWriter, r *http.Request, userRepo repository.UserRepository) (*models.User, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := userRepo.GetUserByID(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", id, err)
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
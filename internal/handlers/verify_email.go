@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sso/internal/mailer"
+	"sso/internal/models"
+	"sso/pkg/token"
+)
+
+const verificationLinkTTL = 24 * time.Hour
+
+func (h *AuthHandler) sendVerificationEmail(r *http.Request, user *models.User) error {
+	linkToken, err := h.tokenManager.GenerateLinkToken(token.PurposeEmailVerification, user.ID, user.Email, verificationLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.tokenManager.Issuer + "/api/verify-email?" + url.Values{"token": {linkToken}}.Encode()
+
+	msg, err := mailer.Render("verify_email", mailer.Locale(r.Header.Get("Accept-Language")), map[string]interface{}{
+		"Link":  link,
+		"Hours": int(verificationLinkTTL.Hours()),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.To = user.Email
+	return h.mailer.Send(msg)
+}
+
+// VerifyEmail consumes a verification link. Opening the link shows a page
+// with a confirmation button, so mail scanners that prefetch links do not use
+// it up. JSON clients POST the token directly.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	if r.Method == http.MethodGet {
+		renderPage(w, http.StatusOK, "verify_email.html", pageData{
+			Title:  "Verify email",
+			Action: "/api/verify-email",
+			Hidden: map[string]string{"token": r.URL.Query().Get("token")},
+		})
+		return
+	}
+
+	var linkToken string
+	if isJSON {
+		var req models.VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		linkToken = req.Token
+	} else {
+		linkToken = r.PostFormValue("token")
+	}
+
+	err := h.verifyEmail(linkToken)
+	if isJSON {
+		if err != nil {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid or expired link")
+		return
+	}
+	renderPage(w, http.StatusOK, "verify_email.html", pageData{
+		Title:   "Verify email",
+		Message: "Your email address is verified. You can close this page.",
+	})
+}
+
+func (h *AuthHandler) verifyEmail(linkToken string) error {
+	claims, err := h.tokenManager.ConsumeLinkToken(linkToken, token.PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	userID, ok := token.UserID(claims)
+	if !ok {
+		return errInvalidCredentials
+	}
+
+	// The link is only valid for the address it was sent to.
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil || user.Email != claims["email"] {
+		return errInvalidCredentials
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	return h.userRepo.SetEmailVerified(user.ID, true)
+}
+
+// ResendVerification sends a new verification link. It always responds with
+// 202 so it does not reveal which addresses are registered.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req models.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByEmail(req.Email)
+	if err == nil && !user.EmailVerified {
+		if err := h.sendVerificationEmail(r, user); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
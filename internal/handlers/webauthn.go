@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"sso/internal/middleware"
+	"sso/internal/models"
+	"sso/internal/repository"
+	"sso/internal/webauthn"
+)
+
+const (
+	// The browser gives up after webauthn.Timeout, the session lasts a little
+	// longer for the response to arrive.
+	webAuthnSessionTTL = webauthn.Timeout + time.Minute
+	passkeyNameLength  = 64
+)
+
+var (
+	errPasskeyRejected = errors.New("passkey not accepted")
+	errPasskeyCloned   = errors.New("passkey sign count did not increase")
+)
+
+// webAuthnUserHandle identifies the user to authenticators. It is returned
+// with assertions of discoverable credentials.
+func webAuthnUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+func credentialDescriptors(credentials []models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+		if err != nil {
+			continue
+		}
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         id,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
+
+// secondFactors returns the confirmed second factors of the user, without
+// the recovery codes that stand in for them.
+func secondFactors(mfaRepo repository.MFARepository, userID uint) ([]string, error) {
+	var methods []string
+
+	credential, err := mfaRepo.GetTOTP(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && credential.Confirmed {
+		methods = append(methods, models.MFAMethodTOTP)
+	}
+
+	passkeys, err := mfaRepo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) > 0 {
+		methods = append(methods, models.MFAMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// requestPasskey starts an assertion ceremony. userID is zero if any
+// discoverable credential may answer.
+func (h *AuthHandler) requestPasskey(userID uint, purpose, userVerification string, credentials []models.WebAuthnCredential) (*models.WebAuthnRequestResponse, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.webauthnSessions.CreateSession(&models.WebAuthnSession{
+		Challenge: challenge,
+		UserID:    userID,
+		Purpose:   purpose,
+	}, webAuthnSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnRequestResponse{
+		Session:   session,
+		PublicKey: h.relyingParty.RequestOptions(challenge, credentialDescriptors(credentials), userVerification),
+	}, nil
+}
+
+// verifyPasskey checks an assertion for the session and stores the new sign
+// count. The credential is also returned with errPasskeyRejected and
+// errPasskeyCloned if it is known, so the failure can be charged to its user.
+func (h *AuthHandler) verifyPasskey(sessionID, purpose string, resp *webauthn.AssertionResponse) (*models.WebAuthnCredential, error) {
+	session, err := h.webauthnSessions.ConsumeSession(sessionID)
+	if err != nil || session.Purpose != purpose {
+		return nil, errMFAChallengeExpired
+	}
+
+	credential, err := h.mfaRepo.GetWebAuthnCredential(base64.RawURLEncoding.EncodeToString(resp.RawID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errPasskeyRejected
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if session.UserID != 0 && credential.UserID != session.UserID {
+		return credential, errPasskeyRejected
+	}
+	// Without a user to expect the authenticator must say whose key it is.
+	handle := resp.Response.UserHandle
+	if session.UserID == 0 && len(handle) == 0 {
+		return credential, errPasskeyRejected
+	}
+	if len(handle) > 0 && !bytes.Equal(handle, webAuthnUserHandle(credential.UserID)) {
+		return credential, errPasskeyRejected
+	}
+
+	// Passwordless login relies on the authenticator to verify the user, as
+	// a second factor possession of the key is enough.
+	requireUserVerification := purpose == models.WebAuthnLogin
+
+	signCount, err := h.relyingParty.VerifyAssertion(resp, session.Challenge, credential.PublicKey, credential.SignCount, requireUserVerification)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		log.Printf("Sign count of passkey %d of user %d did not increase, it may be cloned", credential.ID, credential.UserID)
+		return credential, errPasskeyCloned
+	}
+	if err != nil {
+		return credential, errPasskeyRejected
+	}
+
+	ok, err := h.mfaRepo.UseWebAuthnCredential(credential.ID, credential.SignCount, signCount)
+	if err != nil {
+		return credential, err
+	}
+	if !ok {
+		return credential, errPasskeyCloned
+	}
+
+	return credential, nil
+}
+
+func passkeyRejected(err error) bool {
+	return errors.Is(err, errPasskeyRejected) || errors.Is(err, errPasskeyCloned)
+}
+
+// passkeyFailureReason is logged for a rejected passkey.
+func passkeyFailureReason(err error) string {
+	if errors.Is(err, errPasskeyCloned) {
+		return "webauthn_sign_count"
+	}
+	return "webauthn_invalid"
+}
+
+// MFAWebAuthnOptions returns the assertion options for passing an MFA
+// challenge with a passkey.
+func (h *AuthHandler) MFAWebAuthnOptions(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAWebAuthnOptionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.challengeRepo.GetChallenge(req.MFAToken)
+	if err != nil {
+		http.Error(w, loginErrorMessage(errMFAChallengeExpired), http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := h.mfaRepo.ListWebAuthnCredentials(challenge.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve passkeys", http.StatusInternalServerError)
+		return
+	}
+	if len(credentials) == 0 {
+		http.Error(w, "No passkey is registered", http.StatusBadRequest)
+		return
+	}
+
+	options, err := h.requestPasskey(challenge.UserID, models.WebAuthnMFA, "discouraged", credentials)
+	if err != nil {
+		log.Printf("Failed to create WebAuthn session: %v", err)
+		http.Error(w, "Failed to create passkey options", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// LoginWebAuthnOptions starts a passwordless login. The authenticator offers
+// its discoverable credentials; no email is asked for, as listing the
+// passkeys of an account would tell which emails are registered.
+func (h *AuthHandler) LoginWebAuthnOptions(w http.ResponseWriter, r *http.Request) {
+	options, err := h.requestPasskey(0, models.WebAuthnLogin, "required", nil)
+	if err != nil {
+		log.Printf("Failed to create WebAuthn session: %v", err)
+		http.Error(w, "Failed to create passkey options", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// LoginWebAuthn signs in with a passkey alone. The authenticator has verified
+// the user, so no second factor is asked for.
+func (h *AuthHandler) LoginWebAuthn(w http.ResponseWriter, r *http.Request) {
+	var req models.WebAuthnLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.authenticatePasskey(r, req.Session, &req.Credential)
+	var lockoutErr *lockoutError
+	if errors.As(err, &lockoutErr) {
+		writeLockoutError(w, lockoutErr)
+		return
+	}
+	if errors.Is(err, errAccountDisabled) || errors.Is(err, errPasswordResetRequired) || errors.Is(err, errEmailNotVerified) {
+		http.Error(w, loginErrorMessage(err), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errMFAChallengeExpired) || passkeyRejected(err) {
+		http.Error(w, loginErrorMessage(err), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to check the passkey", http.StatusInternalServerError)
+		return
+	}
+
+	h.issueLogin(w, user, false, req.Scope, repository.LoginMethodWebAuthn)
+}
+
+// authenticatePasskey checks a passwordless login assertion and records the
+// login attempt. It is shared by the JSON API and the hosted login page.
+func (h *AuthHandler) authenticatePasskey(r *http.Request, sessionID string, resp *webauthn.AssertionResponse) (*models.User, error) {
+	// Blocked accounts are refused before the assertion is checked, like
+	// passwords are.
+	credential, err := h.mfaRepo.GetWebAuthnCredential(base64.RawURLEncoding.EncodeToString(resp.RawID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.failUnknownUser(r, "", repository.LoginMethodWebAuthn)
+		return nil, errPasskeyRejected
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.userRepo.GetUserByID(credential.UserID)
+	if err != nil {
+		return nil, errPasskeyRejected
+	}
+
+	if err := h.checkLockout(r, user.ID, user.Email, repository.LoginMethodWebAuthn); err != nil {
+		return nil, err
+	}
+
+	_, err = h.verifyPasskey(sessionID, models.WebAuthnLogin, resp)
+	if passkeyRejected(err) {
+		h.failLogin(r, user, repository.LoginMethodWebAuthn, passkeyFailureReason(err))
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.checkAccount(r, user, repository.LoginMethodWebAuthn); err != nil {
+		return nil, err
+	}
+
+	h.guard.Succeed(user.Email)
+	h.logRepo.StoreLoginAttempt(&repository.LoginAttempt{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Success:   true,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Method:    repository.LoginMethodWebAuthn,
+	})
+
+	return user, nil
+}
+
+// RegisterPasskeyOptions returns the options for creating a passkey. The
+// user's passkeys are excluded, so an authenticator is only registered once.
+func (h *MFAHandler) RegisterPasskeyOptions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	credentials, err := h.mfaRepo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		http.Error(w, "Failed to create passkey options", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.webauthnSessions.CreateSession(&models.WebAuthnSession{
+		Challenge: challenge,
+		UserID:    userID,
+		Purpose:   models.WebAuthnRegister,
+	}, webAuthnSessionTTL)
+	if err != nil {
+		log.Printf("Failed to create WebAuthn session: %v", err)
+		http.Error(w, "Failed to create passkey options", http.StatusInternalServerError)
+		return
+	}
+
+	options := h.relyingParty.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          webAuthnUserHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Email,
+	}, credentialDescriptors(credentials))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WebAuthnCreationResponse{
+		Session:   session,
+		PublicKey: options,
+	})
+}
+
+// RegisterPasskey stores the passkey created with the options. Recovery
+// codes are returned with the first second factor of the user.
+func (h *MFAHandler) RegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var req models.WebAuthnRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.webauthnSessions.ConsumeSession(req.Session)
+	if err != nil || session.Purpose != models.WebAuthnRegister || session.UserID != userID {
+		http.Error(w, "The passkey registration has expired, please start again", http.StatusBadRequest)
+		return
+	}
+
+	verified, err := h.relyingParty.VerifyRegistration(&req.Credential, session.Challenge, false)
+	if err != nil {
+		log.Printf("Rejected passkey of user %d: %v", userID, err)
+		http.Error(w, "The passkey was not accepted", http.StatusBadRequest)
+		return
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(verified.ID)
+	if _, err := h.mfaRepo.GetWebAuthnCredential(credentialID); err == nil {
+		http.Error(w, "The passkey is already registered", http.StatusConflict)
+		return
+	}
+
+	factors, err := secondFactors(h.mfaRepo, userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve second factors", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len([]rune(name)) > passkeyNameLength {
+		name = string([]rune(name)[:passkeyNameLength])
+	}
+
+	credential := &models.WebAuthnCredential{
+		UserID:            userID,
+		CredentialID:      credentialID,
+		PublicKey:         verified.PublicKey,
+		SignCount:         verified.SignCount,
+		AAGUID:            hex.EncodeToString(verified.AAGUID),
+		Transports:        verified.Transports,
+		AttestationFormat: verified.AttestationFormat,
+		BackupEligible:    verified.BackupEligible,
+		Name:              name,
+	}
+	if err := h.mfaRepo.CreateWebAuthnCredential(credential); err != nil {
+		log.Printf("Failed to store passkey of user %d: %v", userID, err)
+		http.Error(w, "Failed to store the passkey", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.WebAuthnRegisterResponse{Credential: credential}
+	if len(factors) == 0 {
+		resp.RecoveryCodes, err = h.mfaRepo.ReplaceRecoveryCodes(userID, recoveryCodeCount)
+		if err != nil {
+			log.Printf("Failed to create recovery codes of user %d: %v", userID, err)
+		}
+	}
+
+	h.logChange(r, userID, "webauthn_added")
+	h.notifyFactorAdded(r, userID, models.MFAMethodWebAuthn, credential.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *MFAHandler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	credentials, err := h.mfaRepo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve passkeys", http.StatusInternalServerError)
+		return
+	}
+	if credentials == nil {
+		credentials = []models.WebAuthnCredential{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// DeletePasskey removes a passkey, e.g. of a lost authenticator. The
+// recovery codes go with the last second factor.
+func (h *MFAHandler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid passkey id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.mfaRepo.DeleteWebAuthnCredential(userID, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to remove the passkey", http.StatusInternalServerError)
+		return
+	}
+
+	h.logChange(r, userID, "webauthn_removed")
+
+	w.WriteHeader(http.StatusNoContent)
+}
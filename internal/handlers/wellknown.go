@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sso/internal/models"
+	"sso/pkg/token"
+)
+
+type WellKnownHandler struct {
+	tokenManager *token.JWTManager
+}
+
+func NewWellKnownHandler(tokenManager *token.JWTManager) *WellKnownHandler {
+	return &WellKnownHandler{
+		tokenManager: tokenManager,
+	}
+}
+
+func (h *WellKnownHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.tokenManager.JWKS())
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document.
+func (h *WellKnownHandler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := h.tokenManager.Issuer
+
+	// Relying parties can only verify ID tokens against the keys published in
+	// the JWKS, HMAC keys are never published.
+	algorithms := []string{}
+	for _, key := range h.tokenManager.Keys.Keys() {
+		if !key.Symmetric() && !contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	scopes := supportedScopes
+	if !h.tokenManager.SignsIDTokens() {
+		scopes = nil
+		for _, scope := range supportedScopes {
+			if scope != "openid" {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(models.DiscoveryDocument{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               models.SupportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "amr", "acr", "nonce", "email", "email_verified", "updated_at"},
+		ACRValuesSupported:                []string{token.ACRSingleFactor, token.ACRMultiFactor, token.ACRPhishingResistant},
+	})
+}
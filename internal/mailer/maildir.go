@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaildirMailer delivers messages into a local maildir, for development
+// without an SMTP server. Any mail client that reads maildirs can open it.
+type MaildirMailer struct {
+	dir  string
+	from string
+}
+
+func NewMaildirMailer(dir, from string) (*MaildirMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MaildirMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (m *MaildirMailer) Send(msg *Message) error {
+	data, err := compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(suffix), hostname)
+
+	// Messages are written to tmp and moved to new, so readers never see a
+	// partially written file.
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
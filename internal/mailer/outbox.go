@@ -0,0 +1,148 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sso/internal/models"
+)
+
+const (
+	outboxBatchSize   = 20
+	outboxLease       = 5 * time.Minute
+	outboxMaxAttempts = 10
+	outboxRetention   = 7 * 24 * time.Hour
+)
+
+// OutboxStore persists mail until it is delivered. ClaimDueMail must be safe
+// to call from several instances at once. Bodies hold single-use links and
+// codes, so MarkMailSent and MarkMailFailed without a retry drop them and only
+// the envelope is kept.
+type OutboxStore interface {
+	EnqueueMail(mail *models.OutboxMail) error
+	ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkMailSent(id uint) error
+	MarkMailFailed(id uint, lastError string, retryAt time.Time) error
+	DeleteSentMail(before time.Time) error
+}
+
+// Outbox stores messages and delivers them through the transport in the
+// background, retrying with exponential backoff when delivery fails.
+type Outbox struct {
+	store     OutboxStore
+	transport Mailer
+	wake      chan struct{}
+}
+
+func NewOutbox(store OutboxStore, transport Mailer) *Outbox {
+	return &Outbox{
+		store:     store,
+		transport: transport,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Send queues the message. It is delivered shortly after by the worker
+// started with Start.
+func (o *Outbox) Send(msg *Message) error {
+	err := o.store.EnqueueMail(&models.OutboxMail{
+		To:      msg.To,
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		HTML:    msg.HTML,
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Start delivers queued mail every interval and whenever a message is sent,
+// until ctx is done.
+func (o *Outbox) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := o.store.DeleteSentMail(time.Now().Add(-outboxRetention)); err != nil {
+					log.Printf("Failed to clean up the mail outbox: %v", err)
+				}
+			case <-o.wake:
+			}
+
+			if err := o.Deliver(); err != nil {
+				log.Printf("Failed to deliver mail: %v", err)
+			}
+		}
+	}()
+}
+
+// Deliver sends the mail that is due. Messages claimed by another instance
+// are skipped.
+func (o *Outbox) Deliver() error {
+	for {
+		mails, err := o.store.ClaimDueMail(outboxBatchSize, outboxLease)
+		if err != nil {
+			return err
+		}
+
+		for _, mail := range mails {
+			o.deliver(&mail)
+		}
+
+		if len(mails) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+func (o *Outbox) deliver(mail *models.OutboxMail) {
+	err := o.transport.Send(&Message{
+		To:      mail.To,
+		Subject: mail.Subject,
+		Text:    mail.Text,
+		HTML:    mail.HTML,
+	})
+	if err == nil {
+		if err := o.store.MarkMailSent(mail.ID); err != nil {
+			log.Printf("Failed to mark mail %d as sent: %v", mail.ID, err)
+		}
+		return
+	}
+
+	var retryAt time.Time
+	if mail.Attempts+1 < outboxMaxAttempts {
+		retryAt = time.Now().Add(retryDelay(mail.Attempts))
+		log.Printf("Failed to send mail %d, retrying at %s: %v", mail.ID, retryAt.Format(time.RFC3339), err)
+	} else {
+		log.Printf("Failed to send mail %d, giving up: %v", mail.ID, err)
+	}
+
+	if err := o.store.MarkMailFailed(mail.ID, err.Error(), retryAt); err != nil {
+		log.Printf("Failed to record mail %d failure: %v", mail.ID, err)
+	}
+}
+
+// retryDelay doubles from 30 seconds up to an hour.
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 0; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
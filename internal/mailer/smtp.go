@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// ErrTLSRequired is returned when the server does not offer STARTTLS and
+// RequireTLS is set. Mail carries sign in links and codes, so it is not sent
+// in the clear unless explicitly allowed.
+var ErrTLSRequired = errors.New("smtp server does not support STARTTLS")
+
+// SMTPConfig configures the SMTP transport. With ImplicitTLS the connection
+// is encrypted from the start (usually port 465), otherwise STARTTLS is used.
+// Without RequireTLS a server that does not offer STARTTLS gets the mail
+// unencrypted.
+type SMTPConfig struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	ImplicitTLS bool
+	RequireTLS  bool
+	// TLSConfig overrides the default TLS settings, e.g. to trust a private CA.
+	TLSConfig *tls.Config
+}
+
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		config: config,
+	}
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	data, err := compose(m.config.From, msg)
+	if err != nil {
+		return err
+	}
+
+	sender, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return err
+	}
+
+	client, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+	if m.config.TLSConfig != nil {
+		tlsConfig = m.config.TLSConfig.Clone()
+	}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if m.config.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !m.config.ImplicitTLS {
+		ok, _ := client.Extension("STARTTLS")
+		if !ok && m.config.RequireTLS {
+			client.Close()
+			return nil, ErrTLSRequired
+		}
+		if ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return client, nil
+}
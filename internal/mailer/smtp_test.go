@@ -0,0 +1,342 @@
+package mailer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the stand-in server received on one connection.
+type smtpSession struct {
+	tls      bool
+	auth     string
+	from     string
+	to       []string
+	data     string
+	commands []string
+}
+
+// smtpServer is a minimal SMTP server accepting a single connection. With a
+// certificate it offers STARTTLS.
+func smtpServer(t *testing.T, cert *tls.Certificate) (int, <-chan *smtpSession) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan *smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		session := &smtpSession{}
+		defer func() { sessions <- session }()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			session.commands = append(session.commands, line)
+			verb, arg, _ := strings.Cut(line, " ")
+
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				lines := []string{"localhost", "AUTH PLAIN"}
+				if cert != nil && !session.tls {
+					lines = append(lines, "STARTTLS")
+				}
+				for i, l := range lines {
+					sep := "-"
+					if i == len(lines)-1 {
+						sep = " "
+					}
+					tp.PrintfLine("250%s%s", sep, l)
+				}
+			case "STARTTLS":
+				tp.PrintfLine("220 ready")
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				conn = tlsConn
+				tp = textproto.NewConn(conn)
+				session.tls = true
+			case "AUTH":
+				_, initial, _ := strings.Cut(arg, " ")
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				session.auth = string(decoded)
+				tp.PrintfLine("235 ok")
+			case "MAIL":
+				session.from = arg
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				session.to = append(session.to, arg)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				// Line endings arrive as CRLF and are read as LF.
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				session.data = string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, sessions
+}
+
+func receive(t *testing.T, sessions <-chan *smtpSession) *smtpSession {
+	t.Helper()
+	select {
+	case session := <-sessions:
+		return session
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP server got no connection")
+		return nil
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool
+// trusting it.
+func testCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+var testMessage = &Message{
+	To:      "user@example.com",
+	Subject: "Подтвердите адрес",
+	Text:    "Open the link:\nhttps://sso.example.com/verify?token=abc",
+	HTML:    `<p><a href="https://sso.example.com/verify?token=abc">Verify</a></p>`,
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	port, sessions := smtpServer(t, nil)
+	m := NewSMTPMailer(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "sso",
+		Password: "secret",
+		From:     "SSO <no-reply@example.com>",
+	})
+
+	if err := m.Send(testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	session := receive(t, sessions)
+
+	if session.auth != "\x00sso\x00secret" {
+		t.Errorf("auth = %q", session.auth)
+	}
+	if session.from != "FROM:<no-reply@example.com>" {
+		t.Errorf("MAIL %s", session.from)
+	}
+	if len(session.to) != 1 || session.to[0] != "TO:<user@example.com>" {
+		t.Errorf("RCPT %v", session.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(session.data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != testMessage.Subject {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if got := msg.Header.Get("From"); got != `"SSO" <no-reply@example.com>` {
+		t.Errorf("From = %q", got)
+	}
+	if got := msg.Header.Get("To"); got != testMessage.To {
+		t.Errorf("To = %q", got)
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q", msg.Header.Get("Message-ID"))
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", testMessage.Text},
+		{"text/html; charset=utf-8", testMessage.HTML},
+	} {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		if got := part.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, want.contentType)
+		}
+		if got := part.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+			t.Errorf("part Content-Transfer-Encoding = %q", got)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("decode part: %v", err)
+		}
+		if string(body) != want.body {
+			t.Errorf("part body = %q, want %q", body, want.body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got %v", err)
+	}
+}
+
+func TestSMTPMailerPlainText(t *testing.T) {
+	port, sessions := smtpServer(t, nil)
+	m := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: port, From: "no-reply@example.com"})
+
+	if err := m.Send(&Message{To: "user@example.com", Subject: "Hi", Text: "line one\nline two"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	session := receive(t, sessions)
+
+	msg, err := mail.ReadMessage(strings.NewReader(session.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if strings.TrimSuffix(string(body), "\n") != "line one\nline two" {
+		t.Errorf("body = %q", body)
+	}
+	for _, command := range session.commands {
+		if strings.HasPrefix(command, "AUTH") {
+			t.Errorf("authenticated without credentials: %q", command)
+		}
+	}
+}
+
+func TestSMTPMailerRequireTLS(t *testing.T) {
+	port, sessions := smtpServer(t, nil)
+	m := NewSMTPMailer(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       port,
+		Username:   "sso",
+		Password:   "secret",
+		From:       "no-reply@example.com",
+		RequireTLS: true,
+	})
+
+	if err := m.Send(testMessage); !errors.Is(err, ErrTLSRequired) {
+		t.Fatalf("Send = %v, want ErrTLSRequired", err)
+	}
+
+	session := receive(t, sessions)
+	if session.auth != "" || session.from != "" || session.data != "" {
+		t.Errorf("credentials or mail sent without TLS: %+v", session)
+	}
+}
+
+func TestSMTPMailerStartTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	port, sessions := smtpServer(t, cert)
+	m := NewSMTPMailer(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       port,
+		Username:   "sso",
+		Password:   "secret",
+		From:       "no-reply@example.com",
+		RequireTLS: true,
+		TLSConfig:  &tls.Config{ServerName: "127.0.0.1", RootCAs: pool},
+	})
+
+	if err := m.Send(testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	session := receive(t, sessions)
+	if !session.tls {
+		t.Error("mail was not sent over TLS")
+	}
+	if session.auth != "\x00sso\x00secret" || session.data == "" {
+		t.Errorf("session = %+v", session)
+	}
+}
+
+func TestSMTPMailerRejectsBadRecipient(t *testing.T) {
+	m := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: 1, From: "no-reply@example.com"})
+
+	err := m.Send(&Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "x", Text: "x"})
+	if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Fatalf("Send = %v", err)
+	}
+}
+
+func TestLogMailerRedacts(t *testing.T) {
+	got := redact("Your code:\n\n123456\n\nor open https://sso.example.com/api/unlock?token=abc in 15 minutes")
+	for _, secret := range []string{"123456", "https://", "token=abc"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("redact left %q in %q", secret, got)
+		}
+	}
+	if !strings.Contains(got, "15 minutes") {
+		t.Errorf("redact removed too much: %q", got)
+	}
+}
@@ -0,0 +1,136 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when no supported locale was requested.
+const DefaultLocale = "en"
+
+// Locales are the languages that every template is translated to.
+var Locales = []string{"en", "ru"}
+
+// Templates live in templates/<locale>/<name>.txt and .html. The text template
+// defines the "subject" block, the HTML template is rendered inside
+// layout.html.
+//
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = mustLoadTemplates()
+
+func mustLoadTemplates() map[string]*emailTemplate {
+	loaded := map[string]*emailTemplate{}
+
+	for _, locale := range Locales {
+		files, err := fs.Glob(templateFS, "templates/"+locale+"/*.txt")
+		if err != nil {
+			panic(err)
+		}
+
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+
+			tmpl := &emailTemplate{
+				text: texttemplate.Must(texttemplate.ParseFS(templateFS, file)),
+			}
+
+			htmlFile := strings.TrimSuffix(file, ".txt") + ".html"
+			if _, err := fs.Stat(templateFS, htmlFile); err == nil {
+				tmpl.html = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html", htmlFile))
+			}
+
+			loaded[locale+"/"+name] = tmpl
+		}
+	}
+
+	return loaded
+}
+
+// Render builds a message from the named template. Templates missing in the
+// requested locale fall back to DefaultLocale.
+func Render(name, locale string, data interface{}) (*Message, error) {
+	tmpl, ok := templates[locale+"/"+name]
+	if !ok {
+		tmpl, ok = templates[DefaultLocale+"/"+name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown email template: %s", name)
+	}
+
+	var subject, text bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if tmpl.html != nil {
+		var html bytes.Buffer
+		if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+			return nil, err
+		}
+		msg.HTML = html.String()
+	}
+
+	return msg, nil
+}
+
+// Locale picks the best supported locale from an Accept-Language header.
+func Locale(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		for _, locale := range Locales {
+			if c.lang == locale {
+				return locale
+			}
+		}
+	}
+
+	return DefaultLocale
+}
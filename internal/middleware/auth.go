@@ -2,10 +2,13 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"sso/pkg/token"
 	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 type AuthMiddleware struct {
@@ -16,7 +19,19 @@ func NewAuthMiddleware(tokenManager *token.JWTManager) *AuthMiddleware {
 	return &AuthMiddleware{tokenManager}
 }
 
+// Authenticate accepts access tokens issued on behalf of a user.
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	return m.authenticate(next, false)
+}
+
+// AllowClients is like Authenticate but also accepts client credentials
+// tokens. For those, "client_id" is set in the context instead of "user_id".
+// Routes using it should also require a scope.
+func (m *AuthMiddleware) AllowClients(next http.Handler) http.Handler {
+	return m.authenticate(next, true)
+}
+
+func (m *AuthMiddleware) authenticate(next http.Handler, allowClients bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
@@ -41,8 +56,47 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		userID := uint(claims["user_id"].(float64))
-		ctx := context.WithValue(r.Context(), "user_id", userID)
+		ctx := context.WithValue(r.Context(), "claims", claims)
+
+		userID, ok := token.UserID(claims)
+		if ok {
+			ctx = context.WithValue(ctx, "user_id", userID)
+		} else {
+			if !allowClients {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			ctx = context.WithValue(ctx, "client_id", claims["client_id"])
+		}
+
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireScope must be chained after Authenticate or AllowClients. Tokens
+// without the scope are rejected as described in RFC 6750 section 3.1.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			granted := token.Scope(claims)
+
+			if !hasScope(granted, scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				http.Error(w, "Insufficient scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasScope(scope, want string) bool {
+	for _, granted := range strings.Fields(scope) {
+		if granted == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	gohandlers "github.com/gorilla/handlers"
+
+	"sso/internal/repository"
+)
+
+// CORSPolicy allows the configured origins plus the origins registered by
+// OAuth clients. Client origins are cached for a short time.
+type CORSPolicy struct {
+	clientRepo repository.ClientRepository
+	static     []string
+	ttl        time.Duration
+
+	mu       sync.Mutex
+	origins  map[string]bool
+	clients  map[string][]string // client origin to the ids of the clients registering it
+	loadedAt time.Time
+}
+
+func NewCORSPolicy(clientRepo repository.ClientRepository, static []string, ttl time.Duration) *CORSPolicy {
+	return &CORSPolicy{
+		clientRepo: clientRepo,
+		static:     static,
+		ttl:        ttl,
+	}
+}
+
+func (p *CORSPolicy) AllowOrigin(origin string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.load()
+	return p.origins[origin] || len(p.clients[origin]) > 0
+}
+
+// clientsOf returns the clients registering origin. It reports false if the
+// origin is configured for every caller.
+func (p *CORSPolicy) clientsOf(origin string) ([]string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.load()
+	if p.origins[origin] {
+		return nil, false
+	}
+	return p.clients[origin], true
+}
+
+func (p *CORSPolicy) load() {
+	if p.origins == nil || time.Since(p.loadedAt) > p.ttl {
+		p.reload()
+	}
+}
+
+func (p *CORSPolicy) reload() {
+	origins := make(map[string]bool)
+	for _, origin := range p.static {
+		origins[origin] = true
+	}
+
+	clients, err := p.clientRepo.ListClients()
+	if err != nil {
+		log.Printf("Failed to load client origins: %v", err)
+		if p.origins != nil {
+			return
+		}
+	}
+	byOrigin := make(map[string][]string)
+	for _, client := range clients {
+		for _, origin := range client.AllowedOrigins {
+			byOrigin[origin] = append(byOrigin[origin], client.ClientID)
+		}
+	}
+
+	p.origins = origins
+	p.clients = byOrigin
+	p.loadedAt = time.Now()
+}
+
+func (p *CORSPolicy) Handler() func(http.Handler) http.Handler {
+	cors := gohandlers.CORS(
+		gohandlers.AllowedOriginValidator(p.AllowOrigin),
+		gohandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		gohandlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Accept", "Accept-Language", "Content-Language", "Origin", "Authorization"}),
+		gohandlers.AllowCredentials(),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return cors(p.isolate(next))
+	}
+}
+
+// isolate keeps each client to its own origins. A request from an origin
+// registered by clients must name one of them, as client_id, with HTTP Basic
+// client authentication or with the client_id of its bearer token, and every
+// client it names must register the origin. Bearer tokens are verified later
+// by Authenticate, a made up client_id only gets the request rejected there.
+func (p *CORSPolicy) isolate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, restricted := p.clientsOf(origin)
+		if !restricted {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		named := requestClients(r)
+		if len(named) == 0 {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		for _, clientID := range named {
+			if !containsString(allowed, clientID) {
+				http.Error(w, "Origin not allowed for this client", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestClients returns the client ids a request is made for.
+func requestClients(r *http.Request) []string {
+	var clients []string
+	if clientID := r.FormValue("client_id"); clientID != "" {
+		clients = append(clients, clientID)
+	}
+	if clientID, _, ok := r.BasicAuth(); ok {
+		clients = append(clients, clientID)
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		claims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(strings.TrimPrefix(auth, "Bearer "), claims); err == nil {
+			// First party tokens have no client_id and are refused.
+			clientID, _ := claims["client_id"].(string)
+			clients = append(clients, clientID)
+		}
+	}
+
+	return clients
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
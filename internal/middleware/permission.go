@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"sso/internal/repository"
+)
+
+type PermissionMiddleware struct {
+	roleRepo repository.RoleRepository
+}
+
+func NewPermissionMiddleware(roleRepo repository.RoleRepository) *PermissionMiddleware {
+	return &PermissionMiddleware{roleRepo}
+}
+
+// RequirePermission must be chained after AuthMiddleware.Authenticate. The
+// permission is checked against the database rather than the token claims,
+// so revoked roles take effect immediately.
+func (m *PermissionMiddleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value("user_id").(uint)
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			allowed, err := m.roleRepo.HasPermission(userID, permission)
+			if err != nil {
+				log.Printf("Failed to check permission %s: %v", permission, err)
+				http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/models"
+	"sso/internal/repository"
+)
+
+// maxPeekedBody bounds how much of a JSON body is read to find the email.
+const maxPeekedBody = 1 << 20
+
+// KeyFunc returns the value a budget is counted by, or "" if the request has
+// none and the budget does not apply.
+type KeyFunc func(r *http.Request) string
+
+// Budget allows Limit requests per Period for each value of Key.
+type Budget struct {
+	Name   string
+	Key    KeyFunc
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimiter limits requests with budgets kept in Redis, so they are shared
+// by all instances.
+type RateLimiter struct {
+	repo repository.RateLimitRepository
+}
+
+func NewRateLimiter(repo repository.RateLimitRepository) *RateLimiter {
+	return &RateLimiter{repo}
+}
+
+// Limit counts requests to the route against the budgets and answers 429 once
+// one of them is exhausted. Budgets with a zero limit are disabled. The
+// RateLimit-* headers describe the most restrictive budget.
+func (l *RateLimiter) Limit(route string, budgets ...Budget) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var limits []repository.RateLimit
+			for _, budget := range budgets {
+				if budget.Limit <= 0 {
+					continue
+				}
+				value := budget.Key(r)
+				if value == "" {
+					continue
+				}
+				limits = append(limits, repository.RateLimit{
+					Key:    route + ":" + budget.Name + ":" + value,
+					Limit:  budget.Limit,
+					Period: budget.Period,
+				})
+			}
+
+			if len(limits) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A Redis outage should not take sign in down with it.
+			result, err := l.repo.Take(limits)
+			if err != nil {
+				log.Printf("Failed to check rate limit of %s: %v", route, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", strconv.Itoa(result.Limit.Limit)+";w="+strconv.Itoa(seconds(result.Limit.Period)))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds up, so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ByIP counts requests per client address, the same one login attempts are
+// recorded with.
+func ByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// ByEmail counts requests per email address in the JSON body or the form.
+// The address is hashed to keep it out of Redis.
+func ByEmail(r *http.Request) string {
+	var email string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekedBody))
+		if err != nil {
+			return ""
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			Email string `json:"email"`
+		}
+		json.Unmarshal(body, &req)
+		email = req.Email
+	} else {
+		email = r.PostFormValue("email")
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:])
+}
+
+// ByClientID counts requests per OAuth client. Only clients that already
+// proved their credentials are counted, a client_id anyone can send would let
+// them use up the budget of another client.
+func ByClientID(r *http.Request) string {
+	if client, ok := r.Context().Value("client").(*models.OAuthClient); ok {
+		return client.ClientID
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP finds the address of the client behind the proxies in trusted,
+// given as single addresses or CIDR ranges. X-Forwarded-For and X-Real-IP
+// are only believed on connections from a trusted proxy, otherwise anyone
+// could pick the address their requests are counted by.
+func RealIP(trusted []string) func(http.Handler) http.Handler {
+	var proxies []*net.IPNet
+	for _, entry := range trusted {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q", entry)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "client_ip", resolveIP(r, proxies))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the address found by RealIP, or the address of the
+// connection for requests that did not pass through it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value("client_ip").(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveIP walks X-Forwarded-For from the right: every proxy appends the
+// address it got the request from, so the first one that is not a trusted
+// proxy is the client. Whatever is left of it was sent by the client itself.
+func resolveIP(r *http.Request, proxies []*net.IPNet) string {
+	ip := remoteIP(r)
+	if !isTrusted(ip, proxies) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+			return realIP.String()
+		}
+		return ip
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop.String()
+		if !isTrusted(ip, proxies) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
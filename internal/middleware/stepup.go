@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sso/internal/models"
+	"sso/pkg/token"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// StepUp describes how recent and how strong the sign in behind a token must
+// be. A zero MaxAge or an empty ACR is not checked.
+type StepUp struct {
+	MaxAge time.Duration
+	ACR    string
+}
+
+// Satisfied reports whether the token was issued after a sign in that meets
+// the policy. Tokens that do not tell when the user signed in never do.
+func (p StepUp) Satisfied(claims jwt.MapClaims) bool {
+	auth := token.AuthenticationFromClaims(claims)
+	if auth.Time.IsZero() {
+		return p.MaxAge == 0 && p.ACR == ""
+	}
+
+	if p.MaxAge > 0 && time.Since(auth.Time) > p.MaxAge {
+		return false
+	}
+	if p.ACR != "" && !token.SatisfiesACR(auth.ACR(), p.ACR) {
+		return false
+	}
+
+	return true
+}
+
+// Reject answers with the insufficient_user_authentication error of RFC 9470,
+// telling the client what kind of sign in to ask the user for.
+func (p StepUp) Reject(w http.ResponseWriter) {
+	response := models.StepUpRequiredResponse{
+		Error:            "insufficient_user_authentication",
+		ErrorDescription: "A more recent or stronger sign in is required",
+		MaxAge:           int64(p.MaxAge.Seconds()),
+		ACRValues:        p.ACR,
+	}
+
+	challenge := []string{
+		fmt.Sprintf("error=%q", response.Error),
+		fmt.Sprintf("error_description=%q", response.ErrorDescription),
+	}
+	if response.ACRValues != "" {
+		challenge = append(challenge, fmt.Sprintf("acr_values=%q", response.ACRValues))
+	}
+	if response.MaxAge > 0 {
+		challenge = append(challenge, fmt.Sprintf("max_age=%d", response.MaxAge))
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(challenge, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RequireStepUp must be chained after Authenticate or AllowClients. Tokens
+// of users who signed in too long ago or too weakly are rejected with
+// StepUp.Reject; client credentials tokens have no sign in and pass.
+func RequireStepUp(policy StepUp) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			if _, isUser := token.UserID(claims); isUser && !policy.Satisfied(claims) {
+				policy.Reject(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+var SupportedGrantTypes = []string{
+	GrantAuthorizationCode,
+	GrantRefreshToken,
+	GrantClientCredentials,
+	GrantDeviceCode,
+}
+
+// OAuthClient is an application registered with the SSO service.
+type OAuthClient struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	ClientID        string     `json:"client_id" gorm:"unique; not null"`
+	SecretHash      string     `json:"-"` // empty for public clients
+	Name            string     `json:"name"`
+	RedirectURIs    StringList `json:"redirect_uris" gorm:"type:text"`
+	GrantTypes      StringList `json:"grant_types" gorm:"type:text"`
+	Scopes          StringList `json:"scopes" gorm:"type:text"`
+	AllowedOrigins  StringList `json:"allowed_origins" gorm:"type:text"` // CORS origins
+	AccessTokenTTL  int        `json:"access_token_ttl"`                 // seconds, 0 keeps the default
+	RefreshTokenTTL int        `json:"refresh_token_ttl"`                // seconds, 0 keeps the default
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirectURI requires an exact match with a registered redirect URI.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	return uri != "" && c.RedirectURIs.Contains(uri)
+}
+
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return c.GrantTypes.Contains(grantType)
+}
+
+// ClientRequest is the admin API payload to create or update a client.
+type ClientRequest struct {
+	ClientID        string   `json:"client_id"`
+	Name            string   `json:"name"`
+	Confidential    bool     `json:"confidential"`
+	RedirectURIs    []string `json:"redirect_uris"`
+	GrantTypes      []string `json:"grant_types"`
+	Scopes          []string `json:"scopes"`
+	AllowedOrigins  []string `json:"allowed_origins"`
+	AccessTokenTTL  int      `json:"access_token_ttl"`
+	RefreshTokenTTL int      `json:"refresh_token_ttl"`
+}
+
+// ClientSecretResponse is returned once, when a client secret is generated.
+type ClientSecretResponse struct {
+	Client       *OAuthClient `json:"client"`
+	ClientSecret string       `json:"client_secret,omitempty"`
+}
+
+// IntrospectionResponse follows RFC 7662. Only Active is set for inactive tokens.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
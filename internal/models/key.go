@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	KeyStatusPending = "pending"
+	KeyStatusActive  = "active"
+	KeyStatusRetired = "retired"
+)
+
+// SigningKey is the persisted state of a token signing key. Every SSO
+// instance loads the keyring from this table.
+type SigningKey struct {
+	ID         string     `json:"kid" gorm:"primaryKey"`
+	Algorithm  string     `json:"alg" gorm:"not null"`
+	PrivateKey string     `json:"-" gorm:"not null"`
+	Status     string     `json:"status" gorm:"index; not null"`
+	Configured bool       `json:"configured"` // seeded from the configuration, not rotated through the API
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // retired keys verify tokens until then
+}
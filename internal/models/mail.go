@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+const (
+	MailStatusPending = "pending"
+	MailStatusSent    = "sent"
+	MailStatusFailed  = "failed"
+)
+
+// OutboxMail is an email waiting to be delivered. Mail is stored before it is
+// sent so it survives an unavailable SMTP server or a restart.
+type OutboxMail struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	To            string     `json:"to" gorm:"not null"`
+	Subject       string     `json:"subject" gorm:"not null"`
+	Text          string     `json:"-" gorm:"not null"`
+	HTML          string     `json:"-"`
+	Status        string     `json:"status" gorm:"not null; index:idx_outbox_due,priority:1"`
+	Attempts      int        `json:"attempts" gorm:"not null; default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null; index:idx_outbox_due,priority:2"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
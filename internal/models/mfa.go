@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"sso/internal/webauthn"
+)
+
+// Second factors accepted when an MFA challenge is completed.
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodWebAuthn     = "webauthn"
+)
+
+// TOTPCredential is the authenticator app of a user. It only counts as a
+// second factor once it is confirmed with a first code. LastStep is the time
+// step of the last accepted code, codes of that step or earlier are rejected.
+type TOTPCredential struct {
+	UserID      uint       `json:"-" gorm:"primaryKey"`
+	Secret      string     `json:"-" gorm:"not null"`
+	Confirmed   bool       `json:"confirmed" gorm:"not null; default:false"`
+	LastStep    int64      `json:"-" gorm:"not null; default:0"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// RecoveryCode is a one-time code for users who lost their authenticator.
+// Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null; index"`
+	CodeHash  string `gorm:"not null; uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// MFAChallenge is the state between a correct first factor and the second.
+// Method is how the user passed the first one.
+type MFAChallenge struct {
+	UserID   uint   `json:"user_id"`
+	Scope    string `json:"scope"`
+	Method   string `json:"method"`
+	Attempts int    `json:"attempts"`
+}
+
+// MFAChallengeResponse is returned by /api/login instead of tokens when the
+// user has a second factor.
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
+
+// MFALoginRequest completes a challenge with a TOTP, a recovery code or a
+// passkey assertion for the options from /api/login/mfa/webauthn/options.
+type MFALoginRequest struct {
+	MFAToken        string                      `json:"mfa_token"`
+	Code            string                      `json:"code,omitempty"`
+	RecoveryCode    string                      `json:"recovery_code,omitempty"`
+	WebAuthnSession string                      `json:"webauthn_session,omitempty"`
+	WebAuthn        *webauthn.AssertionResponse `json:"webauthn,omitempty"`
+}
+
+// MFACodeRequest proves possession of the second factor, e.g. to confirm or
+// remove it.
+type MFACodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// TOTPEnrollmentResponse holds what the authenticator app needs. QRCode is a
+// data: URI of a PNG image of URI.
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatus struct {
+	TOTP              bool `json:"totp"`
+	Passkeys          int  `json:"passkeys"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// AuthorizationCode is the state behind a short-lived code issued by /authorize.
+type AuthorizationCode struct {
+	ClientID            string    `json:"client_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	UserID              uint      `json:"user_id"`
+	Scope               string    `json:"scope"`
+	Nonce               string    `json:"nonce,omitempty"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	AuthTime            time.Time `json:"auth_time"`
+	AMR                 []string  `json:"amr,omitempty"`
+}
+
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+// DeviceAuthorization is the state behind a device code (RFC 8628). UserID,
+// AuthTime and AMR are set once the user approves it on the hosted page.
+type DeviceAuthorization struct {
+	ClientID string    `json:"client_id"`
+	Scope    string    `json:"scope"`
+	UserCode string    `json:"user_code"`
+	Status   string    `json:"status"`
+	UserID   uint      `json:"user_id,omitempty"`
+	AuthTime time.Time `json:"auth_time,omitempty"`
+	AMR      []string  `json:"amr,omitempty"`
+}
+
+// DeviceAuthorizationResponse is the response of /oauth/device_authorization.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// Session is the browser session of the hosted login page.
+type Session struct {
+	ID       string    `json:"-"`
+	UserID   uint      `json:"user_id"`
+	AuthTime time.Time `json:"auth_time"`
+	AMR      []string  `json:"amr,omitempty"`
+}
+
+// OAuthTokenResponse is the response of the /token endpoint (RFC 6749 section 5.1).
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// StepUpRequiredResponse rejects a token whose sign in is too old or too weak
+// for the route (RFC 9470). The client should have the user sign in again,
+// with a second factor if acr_values asks for it, and retry.
+type StepUpRequiredResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	MaxAge           int64  `json:"max_age,omitempty"`
+	ACRValues        string `json:"acr_values,omitempty"`
+}
+
+// DiscoveryDocument is the OpenID Connect provider metadata.
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	ACRValuesSupported                []string `json:"acr_values_supported"`
+}
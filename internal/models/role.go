@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Permissions checked by the SSO service itself.
+const (
+	PermLogsReadAll   = "logs:read_all"
+	PermKeysManage    = "keys:manage"
+	PermClientsManage = "clients:manage"
+	PermRolesManage   = "roles:manage"
+	PermUsersManage   = "users:manage"
+)
+
+var Permissions = []string{
+	PermLogsReadAll,
+	PermKeysManage,
+	PermClientsManage,
+	PermRolesManage,
+	PermUsersManage,
+}
+
+// RoleAdmin is created on startup and holds every permission.
+const RoleAdmin = "admin"
+
+type Role struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"unique; not null"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions" gorm:"-"` // loaded from role_permissions
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RolePermission struct {
+	RoleID     uint   `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey"`
+}
+
+type UserRole struct {
+	UserID    uint `gorm:"primaryKey"`
+	RoleID    uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// RoleRequest is the admin API payload to create or update a role.
+type RoleRequest struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// GrantRoleRequest is the admin API payload to grant a role to a user.
+type GrantRoleRequest struct {
+	Role string `json:"role"`
+}
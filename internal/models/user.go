@@ -3,28 +3,101 @@ package models
 import "time"
 
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Email     string    `json:"email" gorm:"unique; not null"`
-	Password  string    `json:"-"` //hashed pwd no need to client
-	CreatedAt time.Time `json:"created_at"`
-	UpdateAt  time.Time `json:"updated_at"`
+	ID                    uint      `json:"id" gorm:"primaryKey"`
+	Email                 string    `json:"email" gorm:"unique; not null"`
+	Password              string    `json:"-"` //hashed pwd no need to client
+	EmailVerified         bool      `json:"email_verified" gorm:"not null; default:false"`
+	Disabled              bool      `json:"disabled" gorm:"not null; default:false"`
+	PasswordResetRequired bool      `json:"password_reset_required" gorm:"not null; default:false"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdateAt              time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// UserDetails is the admin API view of a user.
+type UserDetails struct {
+	*User
+	Roles       []string   `json:"roles"`
+	MFAEnabled  bool       `json:"mfa_enabled"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// UserList is a page of the admin user listing.
+type UserList struct {
+	Users   []User `json:"users"`
+	Total   int64  `json:"total"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
 }
 
 type AuthRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Scope    string `json:"scope,omitempty"` // space-separated, defaults to "openid profile email"
+}
+
+// EmailLoginRequest asks for a sign in email. Method is "code", the default,
+// for a 6-digit code or "link" for a sign in link.
+type EmailLoginRequest struct {
+	Email  string `json:"email"`
+	Method string `json:"method,omitempty"`
+	Scope  string `json:"scope,omitempty"`
+}
+
+// EmailLoginVerifyRequest signs in with the code from the email or the token
+// of the link.
+type EmailLoginVerifyRequest struct {
+	Email string `json:"email,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// EmailLogin is a pending email login. Scope is the scope requested with it.
+type EmailLogin struct {
+	UserID uint   `json:"user_id"`
+	Scope  string `json:"scope"`
 }
 
 type TokenResponse struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	Warnings     []string  `json:"warnings,omitempty"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
 }
 
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type TokenVerifyResponse struct {
 	Valid  bool `json:"valid"`
 	UserID uint `json:"user_id"`
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"sso/internal/webauthn"
+)
+
+// Purposes of a WebAuthn ceremony.
+const (
+	WebAuthnRegister = "register"
+	WebAuthnMFA      = "mfa"
+	WebAuthnLogin    = "login"
+)
+
+// WebAuthnCredential is a passkey or security key of a user. It serves as a
+// second factor after the password and, if the authenticator verifies the
+// user, for passwordless login. CredentialID is base64url encoded.
+type WebAuthnCredential struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	UserID            uint       `json:"-" gorm:"not null; index"`
+	CredentialID      string     `json:"credential_id" gorm:"not null; uniqueIndex"`
+	PublicKey         []byte     `json:"-" gorm:"not null"`
+	SignCount         uint32     `json:"-" gorm:"not null; default:0"`
+	AAGUID            string     `json:"aaguid"`
+	Transports        StringList `json:"transports" gorm:"type:text"`
+	AttestationFormat string     `json:"attestation_format"`
+	BackupEligible    bool       `json:"backup_eligible" gorm:"not null; default:false"`
+	Name              string     `json:"name"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnSession is the state of a ceremony between the options and the
+// response. UserID is zero for passwordless login.
+type WebAuthnSession struct {
+	Challenge []byte `json:"challenge"`
+	UserID    uint   `json:"user_id"`
+	Purpose   string `json:"purpose"`
+}
+
+// WebAuthnCreationResponse holds the options for
+// navigator.credentials.create() and the session to send them back with.
+type WebAuthnCreationResponse struct {
+	Session   string                   `json:"session"`
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// WebAuthnRequestResponse holds the options for navigator.credentials.get().
+type WebAuthnRequestResponse struct {
+	Session   string                  `json:"session"`
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+type WebAuthnRegisterRequest struct {
+	Session    string                        `json:"session"`
+	Name       string                        `json:"name,omitempty"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// WebAuthnRegisterResponse returns the new passkey. RecoveryCodes are only
+// created with the first second factor of the user.
+type WebAuthnRegisterResponse struct {
+	Credential    *WebAuthnCredential `json:"credential"`
+	RecoveryCodes []string            `json:"recovery_codes,omitempty"`
+}
+
+type WebAuthnLoginRequest struct {
+	Session    string                     `json:"session"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+	Scope      string                     `json:"scope,omitempty"`
+}
+
+type MFAWebAuthnOptionsRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
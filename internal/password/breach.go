@@ -0,0 +1,159 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Actions taken when a password is found in the breach dataset.
+const (
+	BreachReject = "reject"
+	BreachWarn   = "warn"
+	BreachFlag   = "flag"
+)
+
+// BreachChecker reports how often a password was seen in known breaches.
+type BreachChecker interface {
+	BreachCount(password string) (int, error)
+}
+
+// OpenBreachDataset opens a Pwned Passwords SHA-1 dataset. A directory is
+// read as the range partitioned layout, one file per 5 character hash prefix
+// (00000.txt to FFFFF.txt) with "SUFFIX:COUNT" lines. A file is read as the
+// single file layout with "HASH:COUNT" lines ordered by hash.
+func OpenBreachDataset(path string) (BreachChecker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &prefixDirChecker{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sortedFileChecker{file: f, size: info.Size()}, nil
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseCount parses the count after the colon of a dataset line.
+func parseCount(line string) (int, error) {
+	_, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid breach dataset line: %q", line)
+	}
+	return strconv.Atoi(count)
+}
+
+type prefixDirChecker struct {
+	dir string
+}
+
+func (c *prefixDirChecker) BreachCount(password string) (int, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) > len(suffix) && strings.EqualFold(line[:len(suffix)], suffix) && line[len(suffix)] == ':' {
+			return parseCount(line)
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+// sortedFileChecker binary searches the ordered file on disk, so the dataset
+// is never loaded into memory.
+type sortedFileChecker struct {
+	file *os.File
+	size int64
+}
+
+func (c *sortedFileChecker) BreachCount(password string) (int, error) {
+	hash := sha1Hex(password)
+
+	// The line with the hash, if any, starts in [lo, hi).
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		line, start, next, err := c.lineAfter(mid)
+		if err != nil {
+			return 0, err
+		}
+		if line == "" || start >= hi {
+			hi = mid
+			continue
+		}
+		if len(line) < len(hash) {
+			return 0, fmt.Errorf("invalid breach dataset line: %q", line)
+		}
+
+		switch cmp := strings.Compare(strings.ToUpper(line[:len(hash)]), hash); {
+		case cmp == 0:
+			return parseCount(line)
+		case cmp < 0:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, nil
+}
+
+// lineAfter returns the first line that starts at or after offset, its start
+// and the start of the line following it.
+func (c *sortedFileChecker) lineAfter(offset int64) (string, int64, int64, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(c.file, start, c.size-start))
+	pos := start
+
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		pos += int64(len(skipped))
+		if err == io.EOF {
+			return "", pos, pos, nil
+		}
+		if err != nil {
+			return "", pos, pos, err
+		}
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", pos, pos, err
+	}
+
+	return strings.TrimRight(line, "\r\n"), pos, pos + int64(len(line)), nil
+}
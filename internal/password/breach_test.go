@@ -0,0 +1,129 @@
+package password
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeSortedDataset writes the passwords in the single file layout, ordered
+// by hash, with the index of each password as its count.
+func writeSortedDataset(t *testing.T, passwords []string, lineEnd string, lower, trailingNewline bool) BreachChecker {
+	t.Helper()
+
+	lines := make([]string, len(passwords))
+	for i, pw := range passwords {
+		hash := sha1Hex(pw)
+		if lower {
+			hash = strings.ToLower(hash)
+		}
+		lines[i] = fmt.Sprintf("%s:%d", hash, i+1)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return strings.ToUpper(lines[i]) < strings.ToUpper(lines[j])
+	})
+
+	data := strings.Join(lines, lineEnd)
+	if trailingNewline && len(lines) > 0 {
+		data += lineEnd
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := OpenBreachDataset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checker.(*sortedFileChecker); !ok {
+		t.Fatalf("OpenBreachDataset returned %T, want *sortedFileChecker", checker)
+	}
+	t.Cleanup(func() { checker.(*sortedFileChecker).file.Close() })
+
+	return checker
+}
+
+func datasetPasswords(n int) []string {
+	passwords := make([]string, n)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("password-%d", i)
+	}
+	return passwords
+}
+
+func TestSortedFileCheckerFindsEveryLine(t *testing.T) {
+	tests := []struct {
+		name            string
+		size            int
+		lineEnd         string
+		lower           bool
+		trailingNewline bool
+	}{
+		{name: "crlf", size: 500, lineEnd: "\r\n", trailingNewline: true},
+		{name: "lf", size: 500, lineEnd: "\n", trailingNewline: true},
+		{name: "no trailing newline", size: 500, lineEnd: "\r\n"},
+		{name: "lowercase", size: 500, lineEnd: "\n", lower: true, trailingNewline: true},
+		{name: "single line", size: 1, lineEnd: "\r\n", trailingNewline: true},
+		{name: "single line without newline", size: 1, lineEnd: "\n"},
+		{name: "two lines", size: 2, lineEnd: "\n", trailingNewline: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwords := datasetPasswords(tt.size)
+			checker := writeSortedDataset(t, passwords, tt.lineEnd, tt.lower, tt.trailingNewline)
+
+			for i, pw := range passwords {
+				count, err := checker.BreachCount(pw)
+				if err != nil {
+					t.Fatalf("BreachCount(%q): %v", pw, err)
+				}
+				if count != i+1 {
+					t.Errorf("BreachCount(%q) = %d, want %d", pw, count, i+1)
+				}
+			}
+
+			for i := 0; i < 200; i++ {
+				pw := fmt.Sprintf("not-breached-%d", i)
+				count, err := checker.BreachCount(pw)
+				if err != nil {
+					t.Fatalf("BreachCount(%q): %v", pw, err)
+				}
+				if count != 0 {
+					t.Errorf("BreachCount(%q) = %d, want 0", pw, count)
+				}
+			}
+		})
+	}
+}
+
+func TestSortedFileCheckerEmptyFile(t *testing.T) {
+	checker := writeSortedDataset(t, nil, "\n", false, false)
+
+	count, err := checker.BreachCount("password")
+	if err != nil || count != 0 {
+		t.Errorf("BreachCount = %d, %v, want 0, nil", count, err)
+	}
+}
+
+func TestSortedFileCheckerInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.txt")
+	if err := os.WriteFile(path, []byte("0123:1\n4567:2\n89AB:3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := OpenBreachDataset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checker.(*sortedFileChecker).file.Close()
+
+	if _, err := checker.BreachCount("password"); err == nil {
+		t.Error("BreachCount accepted lines shorter than a hash")
+	}
+}
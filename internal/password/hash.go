@@ -0,0 +1,218 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash algorithms.
+const (
+	AlgBcrypt   = "bcrypt"
+	AlgArgon2id = "argon2id"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Argon2Params are encoded into every argon2id hash, so they can be changed
+// without breaking existing hashes. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hasher hashes new passwords with the preferred algorithm and verifies
+// hashes of every supported algorithm. Argon2id hashes are stored in the PHC
+// string format, bcrypt hashes in their modular crypt format.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+
+	// Each slot stands for Argon2.Memory KiB, argon2id hashes wait for
+	// enough of them so logins cannot exhaust the memory.
+	slots   chan struct{}
+	slotsMu sync.Mutex
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// NewHasher returns a Hasher computing argon2id hashes concurrently as long
+// as they fit into memoryLimit KiB, at least one at a time.
+func NewHasher(algorithm string, bcryptCost int, argon2Params Argon2Params, memoryLimit uint32) (*Hasher, error) {
+	switch algorithm {
+	case AlgBcrypt, AlgArgon2id:
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %s", algorithm)
+	}
+
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("invalid bcrypt cost: %d", bcryptCost)
+	}
+
+	if argon2Params.Memory == 0 || argon2Params.Iterations == 0 || argon2Params.Parallelism == 0 {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+
+	slots := memoryLimit / argon2Params.Memory
+	if slots < 1 {
+		slots = 1
+	}
+
+	return &Hasher{
+		Algorithm:  algorithm,
+		BcryptCost: bcryptCost,
+		Argon2:     argon2Params,
+		slots:      make(chan struct{}, slots),
+	}, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return encodeArgon2id(h.Argon2, salt, h.argon2id(password, salt, h.Argon2)), nil
+}
+
+// Verify reports whether password matches the encoded hash.
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+	switch {
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(key, h.argon2id(password, salt, params)) == 1, nil
+	}
+
+	return false, ErrUnknownHash
+}
+
+// VerifyDummy takes as long as Verify of a hash made with the preferred
+// parameters, for when there is no user and so no hash to verify.
+func (h *Hasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummy, _ = h.Hash("dummy password")
+	})
+	h.Verify(password, h.dummy)
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// other parameters than the preferred ones.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if h.Algorithm == AlgBcrypt {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.BcryptCost
+	}
+
+	params, _, _, err := decodeArgon2id(encoded)
+	return err != nil || params != h.Argon2
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// argon2id waits for the slots the memory of p needs. Hashes made with more
+// memory than the preferred parameters take more slots, at most all.
+func (h *Hasher) argon2id(password string, salt []byte, p Argon2Params) []byte {
+	n := int((p.Memory + h.Argon2.Memory - 1) / h.Argon2.Memory)
+	if n > cap(h.slots) {
+		n = cap(h.slots)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	// Slots are taken by one caller at a time, so two cannot each hold
+	// part of what they need and wait for each other.
+	h.slotsMu.Lock()
+	for i := 0; i < n; i++ {
+		h.slots <- struct{}{}
+	}
+	h.slotsMu.Unlock()
+
+	defer func() {
+		for i := 0; i < n; i++ {
+			<-h.slots
+		}
+	}()
+
+	return argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+}
+
+// encodeArgon2id formats $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+func encodeArgon2id(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgArgon2id {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
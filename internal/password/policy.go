@@ -0,0 +1,225 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBytes is the longest password bcrypt can hash, longer passwords are
+// silently truncated by it.
+const MaxBytes = 72
+
+// Character classes that a policy can require.
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+//go:embed banned.txt
+var bundledBanned string
+
+// Violation is a policy rule that a password does not satisfy.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule that a password violates.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	Classes   []string
+	banned    map[string]bool
+
+	breaches     BreachChecker
+	breachAction string
+}
+
+// NewPolicy builds a policy with the bundled list of common passwords. The
+// passwords in bannedFile, one per line, are banned as well.
+func NewPolicy(minLength, maxLength int, classes []string, bannedFile string) (*Policy, error) {
+	if maxLength <= 0 || maxLength > MaxBytes {
+		maxLength = MaxBytes
+	}
+
+	for _, class := range classes {
+		switch class {
+		case ClassLower, ClassUpper, ClassDigit, ClassSymbol:
+		default:
+			return nil, fmt.Errorf("unknown character class: %s", class)
+		}
+	}
+
+	p := &Policy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		Classes:   classes,
+		banned:    map[string]bool{},
+	}
+
+	p.addBanned(strings.NewReader(bundledBanned))
+
+	if bannedFile != "" {
+		f, err := os.Open(bannedFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := p.addBanned(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Policy) addBanned(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			p.banned[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// UseBreachDataset screens passwords against known breaches. Breached
+// passwords are rejected by Check, or reported by BreachAction for the warn
+// and flag actions.
+func (p *Policy) UseBreachDataset(checker BreachChecker, action string) error {
+	switch action {
+	case BreachReject, BreachWarn, BreachFlag:
+	default:
+		return fmt.Errorf("unknown breach action: %s", action)
+	}
+
+	p.breaches = checker
+	p.breachAction = action
+	return nil
+}
+
+// BreachAction returns the warn or flag action if the password was found in
+// a breach, or an empty string.
+func (p *Policy) BreachAction(password string) string {
+	if p.breachAction == BreachReject || !p.breached(password) {
+		return ""
+	}
+	return p.breachAction
+}
+
+// breached fails open, an unreadable dataset must not lock users out.
+func (p *Policy) breached(password string) bool {
+	if p.breaches == nil {
+		return false
+	}
+
+	count, err := p.breaches.BreachCount(password)
+	if err != nil {
+		log.Printf("Failed to check the breach dataset: %v", err)
+		return false
+	}
+
+	return count > 0
+}
+
+// Check returns a *PolicyError if the password violates the policy.
+func (p *Policy) Check(password string) error {
+	var violations []Violation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	// bcrypt counts bytes, so do characters outside ASCII.
+	if len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+
+	for _, class := range p.Classes {
+		if !hasClass(password, class) {
+			violations = append(violations, Violation{
+				Rule:    "character_class",
+				Message: "Password must contain " + classNames[class],
+			})
+		}
+	}
+
+	if p.banned[strings.ToLower(password)] {
+		violations = append(violations, Violation{
+			Rule:    "banned",
+			Message: "Password is too common",
+		})
+	}
+
+	if p.breachAction == BreachReject && p.breached(password) {
+		violations = append(violations, Violation{
+			Rule:    "breached",
+			Message: "Password appears in a known data breach",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+var classNames = map[string]string{
+	ClassLower:  "a lowercase letter",
+	ClassUpper:  "an uppercase letter",
+	ClassDigit:  "a digit",
+	ClassSymbol: "a symbol",
+}
+
+func hasClass(password, class string) bool {
+	for _, r := range password {
+		switch class {
+		case ClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case ClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case ClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case ClassSymbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
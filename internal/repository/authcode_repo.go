@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+type AuthCodeRepository interface {
+	CreateCode(code *models.AuthorizationCode, ttl time.Duration) (string, error)
+	// ConsumeCode returns the code state and deletes it, so a code can be
+	// exchanged only once.
+	ConsumeCode(code string) (*models.AuthorizationCode, error)
+}
+
+type RedisAuthCodeRepository struct {
+	client *redis.Client
+}
+
+func NewRedisAuthCodeRepository(client *redis.Client) *RedisAuthCodeRepository {
+	return &RedisAuthCodeRepository{
+		client: client,
+	}
+}
+
+func authCodeKey(code string) string {
+	return fmt.Sprintf("auth_code:%s", code)
+}
+
+func (r *RedisAuthCodeRepository) CreateCode(code *models.AuthorizationCode, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	value, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(code)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.Set(ctx, authCodeKey(value), data, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+func (r *RedisAuthCodeRepository) ConsumeCode(code string) (*models.AuthorizationCode, error) {
+	ctx := context.Background()
+
+	data, err := r.client.GetDel(ctx, authCodeKey(code)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var authCode models.AuthorizationCode
+	if err := json.Unmarshal(data, &authCode); err != nil {
+		return nil, err
+	}
+
+	return &authCode, nil
+}
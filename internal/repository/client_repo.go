@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"sso/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type ClientRepository interface {
+	GetClientByClientID(clientID string) (*models.OAuthClient, error)
+	ListClients() ([]models.OAuthClient, error)
+	// CreateClient stores the client with a hash of secret. An empty secret
+	// registers a public client.
+	CreateClient(client *models.OAuthClient, secret string) error
+	UpdateClient(client *models.OAuthClient) error
+	SetClientSecret(client *models.OAuthClient, secret string) error
+	DeleteClient(clientID string) error
+}
+
+type GormClientRepository struct {
+	db *gorm.DB
+}
+
+func NewClientRepository(db *gorm.DB) *GormClientRepository {
+	return &GormClientRepository{db}
+}
+
+func (r *GormClientRepository) GetClientByClientID(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	result := r.db.Where("client_id = ?", clientID).First(&client)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &client, nil
+}
+
+func (r *GormClientRepository) ListClients() ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	result := r.db.Order("id").Find(&clients)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return clients, nil
+}
+
+func (r *GormClientRepository) CreateClient(client *models.OAuthClient, secret string) error {
+	if err := hashClientSecret(client, secret); err != nil {
+		return err
+	}
+
+	return r.db.Create(client).Error
+}
+
+func (r *GormClientRepository) UpdateClient(client *models.OAuthClient) error {
+	return r.db.Save(client).Error
+}
+
+func (r *GormClientRepository) SetClientSecret(client *models.OAuthClient, secret string) error {
+	if err := hashClientSecret(client, secret); err != nil {
+		return err
+	}
+
+	return r.db.Model(client).Update("secret_hash", client.SecretHash).Error
+}
+
+func (r *GormClientRepository) DeleteClient(clientID string) error {
+	result := r.db.Where("client_id = ?", clientID).Delete(&models.OAuthClient{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func hashClientSecret(client *models.OAuthClient, secret string) error {
+	if secret == "" {
+		client.SecretHash = ""
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	client.SecretHash = string(hash)
+	return nil
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+// ErrSlowDown is returned when a device code is polled faster than the
+// advertised interval.
+var ErrSlowDown = errors.New("device code polled too frequently")
+
+// userCodeAlphabet has no vowels and no characters that are easily confused,
+// as recommended by RFC 8628 section 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+type DeviceCodeRepository interface {
+	// CreateDeviceCode stores a pending authorization and returns its device
+	// code. The generated user code is set on auth.
+	CreateDeviceCode(auth *models.DeviceAuthorization, ttl time.Duration) (string, error)
+	GetByUserCode(userCode string) (*models.DeviceAuthorization, error)
+	// CompleteUserCode approves or denies a pending authorization. The user
+	// code cannot be used again afterwards.
+	CompleteUserCode(userCode string, auth *models.DeviceAuthorization) error
+	// PollDeviceCode returns the authorization state, or ErrSlowDown if the
+	// previous poll was less than interval ago. Approved and denied
+	// authorizations are deleted when they are returned.
+	PollDeviceCode(deviceCode string, interval time.Duration) (*models.DeviceAuthorization, error)
+}
+
+type RedisDeviceCodeRepository struct {
+	client *redis.Client
+}
+
+func NewRedisDeviceCodeRepository(client *redis.Client) *RedisDeviceCodeRepository {
+	return &RedisDeviceCodeRepository{
+		client: client,
+	}
+}
+
+func deviceCodeKey(code string) string {
+	return fmt.Sprintf("device_code:%s", code)
+}
+
+func devicePollKey(code string) string {
+	return fmt.Sprintf("device_poll:%s", code)
+}
+
+func userCodeKey(code string) string {
+	return fmt.Sprintf("device_user_code:%s", code)
+}
+
+// NormalizeUserCode accepts user codes typed in lower case, with or without
+// the dash.
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func randomUserCode() (string, error) {
+	var code strings.Builder
+	for i := 0; i < 8; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		if i == 4 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+func (r *RedisDeviceCodeRepository) CreateDeviceCode(auth *models.DeviceAuthorization, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	deviceCode, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	// User codes are short, retry on the rare collision.
+	for attempt := 0; ; attempt++ {
+		auth.UserCode, err = randomUserCode()
+		if err != nil {
+			return "", err
+		}
+
+		ok, err := r.client.SetNX(ctx, userCodeKey(auth.UserCode), deviceCode, ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			break
+		}
+		if attempt == 5 {
+			return "", errors.New("failed to generate a unique user code")
+		}
+	}
+
+	auth.Status = models.DeviceStatusPending
+
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.Set(ctx, deviceCodeKey(deviceCode), data, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	return deviceCode, nil
+}
+
+func (r *RedisDeviceCodeRepository) GetByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	ctx := context.Background()
+
+	deviceCode, err := r.client.Get(ctx, userCodeKey(NormalizeUserCode(userCode))).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := r.client.Get(ctx, deviceCodeKey(deviceCode)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var auth models.DeviceAuthorization
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+func (r *RedisDeviceCodeRepository) CompleteUserCode(userCode string, auth *models.DeviceAuthorization) error {
+	ctx := context.Background()
+
+	deviceCode, err := r.client.GetDel(ctx, userCodeKey(NormalizeUserCode(userCode))).Result()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	// XX keeps an expired device code from being resurrected.
+	return r.client.SetArgs(ctx, deviceCodeKey(deviceCode), data, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+}
+
+func (r *RedisDeviceCodeRepository) PollDeviceCode(deviceCode string, interval time.Duration) (*models.DeviceAuthorization, error) {
+	ctx := context.Background()
+
+	ok, err := r.client.SetNX(ctx, devicePollKey(deviceCode), 1, interval).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSlowDown
+	}
+
+	data, err := r.client.Get(ctx, deviceCodeKey(deviceCode)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var auth models.DeviceAuthorization
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+
+	if auth.Status != models.DeviceStatusPending {
+		// Only one poll may receive the tokens.
+		if err := r.client.GetDel(ctx, deviceCodeKey(deviceCode)).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &auth, nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+// ErrInvalidCode is returned for a wrong email login code.
+var ErrInvalidCode = errors.New("invalid email login code")
+
+type EmailLoginRepository interface {
+	// CreateCode returns a new 6-digit code for the user, a code sent
+	// earlier stops working.
+	CreateCode(login *models.EmailLogin, ttl time.Duration) (string, error)
+	// UseCode returns the login the code was created for and deletes it. A
+	// wrong code returns ErrInvalidCode, after maxAttempts of them the code
+	// is deleted as well. Without a code redis.Nil is returned.
+	UseCode(userID uint, code string, maxAttempts int) (*models.EmailLogin, error)
+	// CreateLink returns the token of a new sign in link.
+	CreateLink(login *models.EmailLogin, ttl time.Duration) (string, error)
+	// ConsumeLink returns the login of the link and deletes it, so a link
+	// works only once.
+	ConsumeLink(token string) (*models.EmailLogin, error)
+}
+
+type RedisEmailLoginRepository struct {
+	client *redis.Client
+}
+
+func NewRedisEmailLoginRepository(client *redis.Client) *RedisEmailLoginRepository {
+	return &RedisEmailLoginRepository{
+		client: client,
+	}
+}
+
+func emailLoginCodeKey(userID uint) string {
+	return fmt.Sprintf("email_login_code:%d", userID)
+}
+
+// Only the hash of a link token is stored.
+func emailLoginLinkKey(token string) string {
+	return fmt.Sprintf("email_login_link:%s", hashToken(token))
+}
+
+// A code only has a million values, the user ID keeps the hashes of equal
+// codes apart.
+func hashEmailLoginCode(userID uint, code string) string {
+	return hashToken(fmt.Sprintf("%d:%s", userID, code))
+}
+
+// useCodeScript checks a code and counts wrong ones in one step, so parallel
+// guesses cannot exceed the attempts. It returns {1, scope} for the right
+// code, {0} for a wrong one and nil without a code.
+var useCodeScript = redis.NewScript(`
+local hash = redis.call("HGET", KEYS[1], "code_hash")
+if not hash then
+	return nil
+end
+
+if hash == ARGV[1] then
+	local scope = redis.call("HGET", KEYS[1], "scope")
+	redis.call("DEL", KEYS[1])
+	return {1, scope}
+end
+
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+if attempts >= tonumber(ARGV[2]) then
+	redis.call("DEL", KEYS[1])
+end
+return {0}
+`)
+
+func (r *RedisEmailLoginRepository) CreateCode(login *models.EmailLogin, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	key := emailLoginCodeKey(login.UserID)
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "code_hash", hashEmailLoginCode(login.UserID, code), "scope", login.Scope, "attempts", 0)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (r *RedisEmailLoginRepository) UseCode(userID uint, code string, maxAttempts int) (*models.EmailLogin, error) {
+	ctx := context.Background()
+
+	result, err := useCodeScript.Run(ctx, r.client,
+		[]string{emailLoginCodeKey(userID)},
+		hashEmailLoginCode(userID, code), strconv.Itoa(maxAttempts),
+	).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, _ := result[0].(int64); ok != 1 {
+		return nil, ErrInvalidCode
+	}
+
+	scope, _ := result[1].(string)
+	return &models.EmailLogin{UserID: userID, Scope: scope}, nil
+}
+
+func (r *RedisEmailLoginRepository) CreateLink(login *models.EmailLogin, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.Set(ctx, emailLoginLinkKey(token), data, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *RedisEmailLoginRepository) ConsumeLink(token string) (*models.EmailLogin, error) {
+	ctx := context.Background()
+
+	data, err := r.client.GetDel(ctx, emailLoginLinkKey(token)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var login models.EmailLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
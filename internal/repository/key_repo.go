@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"sso/internal/models"
+	"sso/internal/secrets"
+)
+
+// GormKeyRepository stores private keys sealed by box.
+type GormKeyRepository struct {
+	db  *gorm.DB
+	box *secrets.Box
+}
+
+func NewKeyRepository(db *gorm.DB, box *secrets.Box) *GormKeyRepository {
+	return &GormKeyRepository{db: db, box: box}
+}
+
+// keyContext binds a sealed private key to its key id.
+func keyContext(kid string) string {
+	return "signing_key:" + kid
+}
+
+func (r *GormKeyRepository) ListKeys() ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	result := r.db.
+		Where("status = ?", models.KeyStatusActive).
+		Or("status = ? AND expires_at > ?", models.KeyStatusRetired, time.Now()).
+		Order("created_at DESC").
+		Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i := range keys {
+		privateKey, err := r.box.Open(keys[i].PrivateKey, keyContext(keys[i].ID))
+		if err != nil {
+			return nil, fmt.Errorf("open signing key %s: %w", keys[i].ID, err)
+		}
+		keys[i].PrivateKey = privateKey
+	}
+
+	return keys, nil
+}
+
+func (r *GormKeyRepository) CreateKey(key *models.SigningKey) error {
+	sealed, err := r.box.Seal(key.PrivateKey, keyContext(key.ID))
+	if err != nil {
+		return err
+	}
+
+	row := *key
+	row.PrivateKey = sealed
+
+	// Several instances may try to seed the same configured key at startup.
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+}
+
+// SealKeys encrypts the private keys stored before they were sealed.
+func (r *GormKeyRepository) SealKeys() error {
+	var keys []models.SigningKey
+	if err := r.db.Where("private_key NOT LIKE ?", "enc:%").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if secrets.Sealed(key.PrivateKey) {
+			continue
+		}
+
+		sealed, err := r.box.Seal(key.PrivateKey, keyContext(key.ID))
+		if err != nil {
+			return err
+		}
+
+		err = r.db.Model(&models.SigningKey{}).
+			Where("id = ? AND private_key = ?", key.ID, key.PrivateKey).
+			Update("private_key", sealed).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *GormKeyRepository) ActivateKey(kid string, retireUntil time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		result := tx.Model(&models.SigningKey{}).
+			Where("status = ? AND id <> ?", models.KeyStatusActive, kid).
+			Updates(map[string]interface{}{
+				"status":     models.KeyStatusRetired,
+				"retired_at": now,
+				"expires_at": retireUntil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&models.SigningKey{}).
+			Where("id = ?", kid).
+			Updates(map[string]interface{}{
+				"status":     models.KeyStatusActive,
+				"retired_at": nil,
+				"expires_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+func (r *GormKeyRepository) DeleteExpiredKeys() error {
+	return r.db.
+		Where("status = ? AND expires_at <= ?", models.KeyStatusRetired, time.Now()).
+		Delete(&models.SigningKey{}).Error
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginBlock tells whether logins for a key are refused and for how long.
+// Locked blocks last long and are lifted by an unlock, other blocks are short
+// delays between attempts.
+type LoginBlock struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// LockoutRepository counts failed logins per key, e.g. an account or an IP
+// address, and blocks further attempts.
+type LockoutRepository interface {
+	// RecordFailure adds a failure and returns the number of failures within
+	// the sliding window.
+	RecordFailure(key string, window time.Duration) (int64, error)
+	// GetBlock returns a zero LoginBlock if logins are allowed.
+	GetBlock(key string) (LoginBlock, error)
+	Block(key string, locked bool, ttl time.Duration) error
+	// Unlock lifts every block and forgets the failures.
+	Unlock(key string) error
+}
+
+type RedisLockoutRepository struct {
+	client *redis.Client
+}
+
+func NewRedisLockoutRepository(client *redis.Client) *RedisLockoutRepository {
+	return &RedisLockoutRepository{
+		client: client,
+	}
+}
+
+func loginFailuresKey(key string) string {
+	return fmt.Sprintf("login_failures:%s", key)
+}
+
+func loginDelayKey(key string) string {
+	return fmt.Sprintf("login_delay:%s", key)
+}
+
+func loginLockKey(key string) string {
+	return fmt.Sprintf("login_lock:%s", key)
+}
+
+func (r *RedisLockoutRepository) RecordFailure(key string, window time.Duration) (int64, error) {
+	ctx := context.Background()
+
+	now := time.Now()
+	failures := loginFailuresKey(key)
+
+	// Failures are scored by time, older ones fall out of the window.
+	pipe := r.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, failures, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, failures, redis.Z{Score: float64(now.UnixMilli()), Member: uuid.NewString()})
+	count := pipe.ZCard(ctx, failures)
+	pipe.PExpire(ctx, failures, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return count.Val(), nil
+}
+
+func (r *RedisLockoutRepository) GetBlock(key string) (LoginBlock, error) {
+	ctx := context.Background()
+
+	pipe := r.client.Pipeline()
+	lock := pipe.PTTL(ctx, loginLockKey(key))
+	delay := pipe.PTTL(ctx, loginDelayKey(key))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return LoginBlock{}, err
+	}
+
+	// PTTL is negative for missing keys.
+	if ttl := lock.Val(); ttl > 0 {
+		return LoginBlock{Locked: true, RetryAfter: ttl}, nil
+	}
+	if ttl := delay.Val(); ttl > 0 {
+		return LoginBlock{RetryAfter: ttl}, nil
+	}
+
+	return LoginBlock{}, nil
+}
+
+func (r *RedisLockoutRepository) Block(key string, locked bool, ttl time.Duration) error {
+	ctx := context.Background()
+
+	if locked {
+		return r.client.Set(ctx, loginLockKey(key), 1, ttl).Err()
+	}
+	return r.client.Set(ctx, loginDelayKey(key), 1, ttl).Err()
+}
+
+func (r *RedisLockoutRepository) Unlock(key string) error {
+	ctx := context.Background()
+	return r.client.Del(ctx, loginLockKey(key), loginDelayKey(key), loginFailuresKey(key)).Err()
+}
@@ -13,11 +13,34 @@ type LoginAttempt struct {
 	UserID    uint      `json:"user_id"`
 	Email     string    `json:"email"`
 	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
 	Success   bool      `json:"success"`
 	IP        string    `json:"ip"`
 	UserAgent string    `json:"user_agent"`
+	Method    string    `json:"method,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
 }
 
+// Methods a user signed in with, recorded in LoginAttempt.Method. With a
+// second factor the attempt that completes it keeps the method of the first.
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodWebAuthn  = "webauthn"
+	LoginMethodEmailCode = "email_code"
+	LoginMethodEmailLink = "email_link"
+)
+
+// Events recorded in LoginAttempt.Event. Attempts stored without one are
+// sign ins, as are those stored before events were recorded.
+const (
+	EventLogin          = "login"
+	EventPasswordReset  = "password_reset"
+	EventPasswordChange = "password_change"
+	EventMFAChange      = "mfa_change"
+	EventAccountUnlock  = "account_unlock"
+	EventTokenReuse     = "token_reuse"
+)
+
 type LogRepository interface {
 	StoreLoginAttempt(attempt *LoginAttempt) error
 	GetUserLogs(userID uint) ([]LoginAttempt, error)
@@ -28,7 +51,7 @@ type RedisLogRepository struct {
 	client *redis.Client
 }
 
-func NewRedisLogRepository(redisURL string) (*RedisLogRepository, error) {
+func NewRedisClient(redisURL string) (*redis.Client, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
@@ -41,9 +64,13 @@ func NewRedisLogRepository(redisURL string) (*RedisLogRepository, error) {
 		return nil, err
 	}
 
+	return client, nil
+}
+
+func NewRedisLogRepository(client *redis.Client) *RedisLogRepository {
 	return &RedisLogRepository{
 		client: client,
-	}, nil
+	}
 }
 
 func (r *RedisLogRepository) StoreLoginAttempt(attempt *LoginAttempt) error {
@@ -52,6 +79,9 @@ func (r *RedisLogRepository) StoreLoginAttempt(attempt *LoginAttempt) error {
 	if attempt.Timestamp.IsZero() {
 		attempt.Timestamp = time.Now()
 	}
+	if attempt.Event == "" {
+		attempt.Event = EventLogin
+	}
 
 	data, err := json.Marshal(attempt)
 	if err != nil {
@@ -104,6 +134,9 @@ func parseLogStrings(stringLogs []string) ([]LoginAttempt, error) {
 		if err := json.Unmarshal([]byte(logString), &log); err != nil {
 			return nil, err
 		}
+		if log.Event == "" {
+			log.Event = EventLogin
+		}
 		logs = append(logs, log)
 	}
 
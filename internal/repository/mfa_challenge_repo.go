@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+type MFAChallengeRepository interface {
+	// CreateChallenge returns a token identifying the challenge.
+	CreateChallenge(challenge *models.MFAChallenge, ttl time.Duration) (string, error)
+	GetChallenge(token string) (*models.MFAChallenge, error)
+	// FailChallenge counts a wrong code and returns the number of attempts.
+	FailChallenge(token string) (int, error)
+	// DeleteChallenge returns false if the challenge was already completed
+	// or has expired.
+	DeleteChallenge(token string) (bool, error)
+}
+
+type RedisMFAChallengeRepository struct {
+	client *redis.Client
+}
+
+func NewRedisMFAChallengeRepository(client *redis.Client) *RedisMFAChallengeRepository {
+	return &RedisMFAChallengeRepository{
+		client: client,
+	}
+}
+
+// Only the hash of a challenge token is stored.
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("mfa_challenge:%s", hashToken(token))
+}
+
+func mfaAttemptsKey(token string) string {
+	return fmt.Sprintf("mfa_attempts:%s", hashToken(token))
+}
+
+func (r *RedisMFAChallengeRepository) CreateChallenge(challenge *models.MFAChallenge, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.Set(ctx, mfaChallengeKey(token), data, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *RedisMFAChallengeRepository) GetChallenge(token string) (*models.MFAChallenge, error) {
+	ctx := context.Background()
+
+	pipe := r.client.Pipeline()
+	data := pipe.Get(ctx, mfaChallengeKey(token))
+	attempts := pipe.Get(ctx, mfaAttemptsKey(token))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	raw, err := data.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge models.MFAChallenge
+	if err := json.Unmarshal(raw, &challenge); err != nil {
+		return nil, err
+	}
+
+	challenge.Attempts, _ = attempts.Int()
+	return &challenge, nil
+}
+
+func (r *RedisMFAChallengeRepository) FailChallenge(token string) (int, error) {
+	ctx := context.Background()
+
+	ttl, err := r.client.PTTL(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, redis.Nil
+	}
+
+	pipe := r.client.TxPipeline()
+	attempts := pipe.Incr(ctx, mfaAttemptsKey(token))
+	pipe.PExpire(ctx, mfaAttemptsKey(token), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return int(attempts.Val()), nil
+}
+
+func (r *RedisMFAChallengeRepository) DeleteChallenge(token string) (bool, error) {
+	ctx := context.Background()
+
+	pipe := r.client.TxPipeline()
+	deleted := pipe.Del(ctx, mfaChallengeKey(token))
+	pipe.Del(ctx, mfaAttemptsKey(token))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	return deleted.Val() == 1, nil
+}
@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"sso/internal/models"
+	"sso/internal/secrets"
+)
+
+type MFARepository interface {
+	GetTOTP(userID uint) (*models.TOTPCredential, error)
+	// SaveTOTP starts an enrollment and replaces an unconfirmed one.
+	SaveTOTP(credential *models.TOTPCredential) error
+	// ConfirmTOTP enables the credential and records the step of the code it
+	// was confirmed with.
+	ConfirmTOTP(userID uint, step int64) error
+	// UseTOTPStep records a code as used. It returns false if a code of the
+	// same or a later step was already accepted.
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	// DeleteTOTP removes the credential, and the recovery codes unless a
+	// passkey remains.
+	DeleteTOTP(userID uint) error
+	// ReplaceRecoveryCodes returns n new codes, the previous ones stop working.
+	ReplaceRecoveryCodes(userID uint, n int) ([]string, error)
+	// UseRecoveryCode returns false if the code is unknown or already used.
+	UseRecoveryCode(userID uint, code string) (bool, error)
+	CountRecoveryCodes(userID uint) (int64, error)
+	CreateWebAuthnCredential(credential *models.WebAuthnCredential) error
+	ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error)
+	// GetWebAuthnCredential looks a credential up by its base64url ID.
+	GetWebAuthnCredential(credentialID string) (*models.WebAuthnCredential, error)
+	// UseWebAuthnCredential stores the sign count of an assertion. It returns
+	// false if another assertion was stored since signCount was read.
+	UseWebAuthnCredential(id uint, signCount, newSignCount uint32) (bool, error)
+	// DeleteWebAuthnCredential removes a passkey of the user, and the recovery
+	// codes if no second factor remains.
+	DeleteWebAuthnCredential(userID, id uint) error
+	// DeleteFactors removes every second factor of the user.
+	DeleteFactors(userID uint) error
+}
+
+// GormMFARepository stores TOTP secrets sealed by box.
+type GormMFARepository struct {
+	db  *gorm.DB
+	box *secrets.Box
+}
+
+func NewMFARepository(db *gorm.DB, box *secrets.Box) *GormMFARepository {
+	return &GormMFARepository{db: db, box: box}
+}
+
+// totpContext binds a sealed secret to its user.
+func totpContext(userID uint) string {
+	return "totp:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func (r *GormMFARepository) GetTOTP(userID uint) (*models.TOTPCredential, error) {
+	var credential models.TOTPCredential
+	if err := r.db.Where("user_id = ?", userID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+
+	secret, err := r.box.Open(credential.Secret, totpContext(userID))
+	if err != nil {
+		return nil, err
+	}
+	credential.Secret = secret
+
+	return &credential, nil
+}
+
+func (r *GormMFARepository) SaveTOTP(credential *models.TOTPCredential) error {
+	sealed, err := r.box.Seal(credential.Secret, totpContext(credential.UserID))
+	if err != nil {
+		return err
+	}
+	row := *credential
+	row.Secret = sealed
+
+	// A confirmed credential stays and makes the insert fail.
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND confirmed = ?", credential.UserID, false).
+			Delete(&models.TOTPCredential{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&row).Error
+	})
+}
+
+// SealTOTPSecrets encrypts the secrets stored before they were sealed.
+func (r *GormMFARepository) SealTOTPSecrets() error {
+	var credentials []models.TOTPCredential
+	if err := r.db.Where("secret NOT LIKE ?", "enc:%").Find(&credentials).Error; err != nil {
+		return err
+	}
+
+	for _, credential := range credentials {
+		if secrets.Sealed(credential.Secret) {
+			continue
+		}
+
+		sealed, err := r.box.Seal(credential.Secret, totpContext(credential.UserID))
+		if err != nil {
+			return err
+		}
+
+		err = r.db.Model(&models.TOTPCredential{}).
+			Where("user_id = ? AND secret = ?", credential.UserID, credential.Secret).
+			Update("secret", sealed).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *GormMFARepository) ConfirmTOTP(userID uint, step int64) error {
+	result := r.db.Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND confirmed = ?", userID, false).
+		Updates(map[string]interface{}{
+			"confirmed":    true,
+			"last_step":    step,
+			"confirmed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UseTOTPStep is a conditional update, so two instances racing with the same
+// code cannot both accept it.
+func (r *GormMFARepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND confirmed = ? AND last_step < ?", userID, true, step).
+		Update("last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormMFARepository) DeleteTOTP(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return deleteUnusedRecoveryCodes(tx, userID)
+	})
+}
+
+// deleteUnusedRecoveryCodes removes the recovery codes once the user has no
+// second factor they could stand in for.
+func deleteUnusedRecoveryCodes(tx *gorm.DB, userID uint) error {
+	var factors int64
+	if err := tx.Model(&models.TOTPCredential{}).Where("user_id = ? AND confirmed = ?", userID, true).Count(&factors).Error; err != nil {
+		return err
+	}
+	if factors > 0 {
+		return nil
+	}
+
+	if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&factors).Error; err != nil {
+		return err
+	}
+	if factors > 0 {
+		return nil
+	}
+
+	return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+func (r *GormMFARepository) ReplaceRecoveryCodes(userID uint, n int) ([]string, error) {
+	codes := make([]string, n)
+	rows := make([]models.RecoveryCode, n)
+	for i := range codes {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (r *GormMFARepository) UseRecoveryCode(userID uint, code string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormMFARepository) CountRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// randomRecoveryCode returns 50 random bits as "xxxxx-xxxxx", easy to copy
+// from paper.
+func randomRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without the
+// dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func (r *GormMFARepository) CreateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	return r.db.Create(credential).Error
+}
+
+func (r *GormMFARepository) ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&credentials).Error
+	return credentials, err
+}
+
+func (r *GormMFARepository) GetWebAuthnCredential(credentialID string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := r.db.Where("credential_id = ?", credentialID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// UseWebAuthnCredential is a conditional update like UseTOTPStep, so an
+// assertion replayed to another instance cannot move the counter twice.
+func (r *GormMFARepository) UseWebAuthnCredential(id uint, signCount, newSignCount uint32) (bool, error) {
+	result := r.db.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", id, signCount).
+		Updates(map[string]interface{}{
+			"sign_count":   newSignCount,
+			"last_used_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormMFARepository) DeleteWebAuthnCredential(userID, id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userID).Delete(&models.WebAuthnCredential{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return deleteUnusedRecoveryCodes(tx, userID)
+	})
+}
+
+func (r *GormMFARepository) DeleteFactors(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, factor := range []interface{}{&models.TOTPCredential{}, &models.WebAuthnCredential{}, &models.RecoveryCode{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(factor).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
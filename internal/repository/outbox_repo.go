@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"sso/internal/models"
+	"sso/internal/secrets"
+)
+
+// GormOutboxRepository stores mail bodies sealed by box, as they hold links
+// and codes until the mail is delivered.
+type GormOutboxRepository struct {
+	db  *gorm.DB
+	box *secrets.Box
+}
+
+func NewOutboxRepository(db *gorm.DB, box *secrets.Box) *GormOutboxRepository {
+	return &GormOutboxRepository{db: db, box: box}
+}
+
+// mailContext binds sealed bodies to the recipient.
+func mailContext(to string) string {
+	return "mail:" + to
+}
+
+func (r *GormOutboxRepository) EnqueueMail(mail *models.OutboxMail) error {
+	mail.Status = models.MailStatusPending
+	if mail.NextAttemptAt.IsZero() {
+		mail.NextAttemptAt = time.Now()
+	}
+
+	row := *mail
+	var err error
+	if row.Text, err = r.box.Seal(mail.Text, mailContext(mail.To)); err != nil {
+		return err
+	}
+	if mail.HTML != "" {
+		if row.HTML, err = r.box.Seal(mail.HTML, mailContext(mail.To)); err != nil {
+			return err
+		}
+	}
+
+	if err := r.db.Create(&row).Error; err != nil {
+		return err
+	}
+	mail.ID = row.ID
+	return nil
+}
+
+// openMail decrypts the bodies of a claimed message.
+func (r *GormOutboxRepository) openMail(mail *models.OutboxMail) error {
+	var err error
+	if mail.Text, err = r.box.Open(mail.Text, mailContext(mail.To)); err != nil {
+		return err
+	}
+	if mail.HTML, err = r.box.Open(mail.HTML, mailContext(mail.To)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimDueMail returns up to limit pending messages that are due and hides
+// them from other instances for lease. Rows locked by another instance are
+// skipped.
+func (r *GormOutboxRepository) ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	var mails []models.OutboxMail
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.MailStatusPending, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&mails)
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(mails) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(mails))
+		for i, mail := range mails {
+			ids[i] = mail.ID
+		}
+
+		return tx.Model(&models.OutboxMail{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Bodies that cannot be decrypted, e.g. after ENCRYPTION_KEY changed,
+	// will never be sent.
+	opened := mails[:0]
+	for _, mail := range mails {
+		if err := r.openMail(&mail); err != nil {
+			if err := r.MarkMailFailed(mail.ID, err.Error(), time.Time{}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		opened = append(opened, mail)
+	}
+
+	return opened, nil
+}
+
+// MarkMailSent records the delivery and drops the body, which is not needed
+// any more and may hold a live link or code.
+func (r *GormOutboxRepository) MarkMailSent(id uint) error {
+	return r.db.Model(&models.OutboxMail{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     models.MailStatusSent,
+			"sent_at":    time.Now(),
+			"last_error": "",
+			"text":       "",
+			"html":       "",
+		}).Error
+}
+
+// MarkMailFailed records a failed attempt. A zero retryAt gives up on the
+// message and drops its body like MarkMailSent.
+func (r *GormOutboxRepository) MarkMailFailed(id uint, lastError string, retryAt time.Time) error {
+	updates := map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": lastError,
+	}
+	if retryAt.IsZero() {
+		updates["status"] = models.MailStatusFailed
+		updates["text"] = ""
+		updates["html"] = ""
+	} else {
+		updates["next_attempt_at"] = retryAt
+	}
+
+	return r.db.Model(&models.OutboxMail{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteSentMail removes delivered messages older than before. Bodies of
+// finished messages stored before they were dropped on delivery are cleared
+// as well.
+func (r *GormOutboxRepository) DeleteSentMail(before time.Time) error {
+	err := r.db.
+		Where("status = ? AND sent_at < ?", models.MailStatusSent, before).
+		Delete(&models.OutboxMail{}).Error
+	if err != nil {
+		return err
+	}
+
+	return r.db.Model(&models.OutboxMail{}).
+		Where("status <> ? AND (text <> '' OR html <> '')", models.MailStatusPending).
+		Updates(map[string]interface{}{"text": "", "html": ""}).Error
+}
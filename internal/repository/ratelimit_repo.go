@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeRateScript implements GCRA over several keys at once. Each key stores
+// its theoretical arrival time in microseconds of the Redis clock, so every
+// SSO instance sharing the Redis sees the same state. A request is only
+// counted if all keys allow it.
+//
+// ARGV holds the emission interval and period in microseconds for each key.
+// Returns the index of the most restrictive key (1-based), whether the
+// request is allowed, the remaining requests of that key and the
+// microseconds until a retry is allowed and until the key is fully reset.
+var takeRateScript = redis.NewScript(`
+local now = redis.call("TIME")
+now = tonumber(now[1]) * 1000000 + tonumber(now[2])
+
+local tats = {}
+local worst, worstRemaining = 1, nil
+local denied, retryAfter = nil, 0
+for i, key in ipairs(KEYS) do
+	local emission = tonumber(ARGV[i * 2 - 1])
+	local period = tonumber(ARGV[i * 2])
+	local tat = tonumber(redis.call("GET", key) or now)
+	if tat < now then
+		tat = now
+	end
+	local newTat = tat + emission
+	local allowAt = newTat - period
+	if allowAt > now then
+		if not denied or allowAt - now > retryAfter then
+			denied, retryAfter = i, allowAt - now
+		end
+	else
+		local remaining = math.floor((period - (newTat - now)) / emission)
+		if not worstRemaining or remaining < worstRemaining then
+			worst, worstRemaining = i, remaining
+		end
+	end
+	tats[i] = {tat, newTat}
+end
+
+if denied then
+	return {denied, 0, 0, retryAfter, tats[denied][1] - now}
+end
+
+for i, key in ipairs(KEYS) do
+	redis.call("SET", key, tats[i][2], "PX", math.ceil((tats[i][2] - now) / 1000))
+end
+return {worst, 1, worstRemaining, 0, tats[worst][2] - now}
+`)
+
+// RateLimit allows Limit requests per Period for Key.
+type RateLimit struct {
+	Key    string
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult describes the most restrictive of the limits of a request.
+type RateLimitResult struct {
+	Limit      RateLimit
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+type RateLimitRepository interface {
+	// Take counts a request against all limits, unless one of them is
+	// exhausted.
+	Take(limits []RateLimit) (RateLimitResult, error)
+}
+
+type RedisRateLimitRepository struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimitRepository(client *redis.Client) *RedisRateLimitRepository {
+	return &RedisRateLimitRepository{
+		client: client,
+	}
+}
+
+func rateLimitKey(key string) string {
+	return fmt.Sprintf("rate_limit:%s", key)
+}
+
+func (r *RedisRateLimitRepository) Take(limits []RateLimit) (RateLimitResult, error) {
+	ctx := context.Background()
+
+	keys := make([]string, len(limits))
+	args := make([]interface{}, 0, len(limits)*2)
+	for i, limit := range limits {
+		keys[i] = rateLimitKey(limit.Key)
+		period := limit.Period.Microseconds()
+		args = append(args, period/int64(limit.Limit), period)
+	}
+
+	values, err := takeRateScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return RateLimitResult{
+		Limit:      limits[values[0]-1],
+		Allowed:    values[1] == 1,
+		Remaining:  int(values[2]),
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+		Reset:      time.Duration(values[4]) * time.Microsecond,
+	}, nil
+}
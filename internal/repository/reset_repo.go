@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type PasswordResetRepository interface {
+	// CreateResetToken returns a new reset token for the user. Tokens issued
+	// earlier stop working.
+	CreateResetToken(userID uint, ttl time.Duration) (string, error)
+	// ConsumeResetToken returns the user the token was issued to and deletes
+	// it, so a token can be used only once.
+	ConsumeResetToken(token string) (uint, error)
+}
+
+type RedisPasswordResetRepository struct {
+	client *redis.Client
+}
+
+func NewRedisPasswordResetRepository(client *redis.Client) *RedisPasswordResetRepository {
+	return &RedisPasswordResetRepository{
+		client: client,
+	}
+}
+
+// Only the hash of a reset token is stored.
+func resetTokenKey(hash string) string {
+	return fmt.Sprintf("password_reset:%s", hash)
+}
+
+func userResetKey(userID uint) string {
+	return fmt.Sprintf("password_reset_user:%d", userID)
+}
+
+func (r *RedisPasswordResetRepository) CreateResetToken(userID uint, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	hash := hashToken(token)
+
+	previous, err := r.client.GetSet(ctx, userResetKey(userID), hash).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	pipe := r.client.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, resetTokenKey(previous))
+	}
+	pipe.Set(ctx, resetTokenKey(hash), userID, ttl)
+	pipe.Expire(ctx, userResetKey(userID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *RedisPasswordResetRepository) ConsumeResetToken(token string) (uint, error) {
+	ctx := context.Background()
+
+	hash := hashToken(token)
+
+	value, err := r.client.GetDel(ctx, resetTokenKey(hash)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the index if a newer token was issued in the meantime.
+	if current, _ := r.client.Get(ctx, userResetKey(uint(userID))).Result(); current == hash {
+		r.client.Del(ctx, userResetKey(uint(userID)))
+	}
+
+	return uint(userID), nil
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"sso/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleRepository interface {
+	ListRoles() ([]models.Role, error)
+	GetRole(name string) (*models.Role, error)
+	// SaveRole creates the role or replaces its description and permissions.
+	SaveRole(role *models.Role) error
+	GetUserRoles(userID uint) ([]string, error)
+	GetUserPermissions(userID uint) ([]string, error)
+	HasPermission(userID uint, permission string) (bool, error)
+	GrantRole(userID uint, role string) error
+	RevokeRole(userID uint, role string) error
+	// CountRoleMembers returns how many users hold the role.
+	CountRoleMembers(role string) (int64, error)
+}
+
+type GormRoleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *GormRoleRepository {
+	return &GormRoleRepository{db}
+}
+
+func (r *GormRoleRepository) ListRoles() ([]models.Role, error) {
+	var roles []models.Role
+	if err := r.db.Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	var permissions []models.RolePermission
+	if err := r.db.Order("permission").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	byRole := make(map[uint][]string)
+	for _, p := range permissions {
+		byRole[p.RoleID] = append(byRole[p.RoleID], p.Permission)
+	}
+	for i := range roles {
+		roles[i].Permissions = byRole[roles[i].ID]
+	}
+
+	return roles, nil
+}
+
+func (r *GormRoleRepository) GetRole(name string) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+
+	err := r.db.Model(&models.RolePermission{}).
+		Where("role_id = ?", role.ID).
+		Order("permission").
+		Pluck("permission", &role.Permissions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (r *GormRoleRepository) SaveRole(role *models.Role) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description"}),
+		}).Create(role).Error
+		if err != nil {
+			return err
+		}
+
+		// The upsert does not return the id of an existing row.
+		if err := tx.Where("name = ?", role.Name).First(role).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		for _, permission := range role.Permissions {
+			if err := tx.Create(&models.RolePermission{RoleID: role.ID, Permission: permission}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *GormRoleRepository) GetUserRoles(userID uint) ([]string, error) {
+	var roles []string
+	err := r.db.Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &roles).Error
+	return roles, err
+}
+
+func (r *GormRoleRepository) GetUserPermissions(userID uint) ([]string, error) {
+	var permissions []string
+	err := r.db.Model(&models.RolePermission{}).
+		Distinct("role_permissions.permission").
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Order("role_permissions.permission").
+		Pluck("role_permissions.permission", &permissions).Error
+	return permissions, err
+}
+
+func (r *GormRoleRepository) HasPermission(userID uint, permission string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.RolePermission{}).
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ? AND role_permissions.permission = ?", userID, permission).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *GormRoleRepository) GrantRole(userID uint, role string) error {
+	found, err := r.GetRole(role)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.UserRole{UserID: userID, RoleID: found.ID}).Error
+}
+
+func (r *GormRoleRepository) RevokeRole(userID uint, role string) error {
+	found, err := r.GetRole(role)
+	if err != nil {
+		return err
+	}
+
+	result := r.db.Where("user_id = ? AND role_id = ?", userID, found.ID).Delete(&models.UserRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *GormRoleRepository) CountRoleMembers(role string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.name = ?", role).
+		Count(&count).Error
+	return count, err
+}
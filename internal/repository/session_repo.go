@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+type SessionRepository interface {
+	CreateSession(session *models.Session, ttl time.Duration) error
+	GetSession(id string) (*models.Session, error)
+	DeleteSession(id string) error
+	// DeleteUserSessions signs the user out of the hosted login pages.
+	DeleteUserSessions(userID uint) error
+}
+
+type RedisSessionRepository struct {
+	client *redis.Client
+}
+
+func NewRedisSessionRepository(client *redis.Client) *RedisSessionRepository {
+	return &RedisSessionRepository{
+		client: client,
+	}
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("sso_session:%s", id)
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("user_sessions:%d", userID)
+}
+
+// CreateSession stores the session under a new random id and sets session.ID.
+func (r *RedisSessionRepository) CreateSession(session *models.Session, ttl time.Duration) error {
+	ctx := context.Background()
+
+	id, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, sessionKey(id), data, ttl)
+	pipe.SAdd(ctx, userSessionsKey(session.UserID), id)
+	pipe.Expire(ctx, userSessionsKey(session.UserID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	session.ID = id
+	return nil
+}
+
+func (r *RedisSessionRepository) GetSession(id string) (*models.Session, error) {
+	ctx := context.Background()
+
+	data, err := r.client.Get(ctx, sessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+
+	session.ID = id
+	return &session, nil
+}
+
+func (r *RedisSessionRepository) DeleteSession(id string) error {
+	ctx := context.Background()
+	return r.client.Del(ctx, sessionKey(id)).Err()
+}
+
+func (r *RedisSessionRepository) DeleteUserSessions(userID uint) error {
+	ctx := context.Background()
+
+	ids, err := r.client.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{userSessionsKey(userID)}
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+
+	return r.client.Del(ctx, keys...).Err()
+}
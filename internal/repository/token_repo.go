@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/pkg/token"
+)
+
+// rotateFamilyScript atomically swaps the latest refresh token id of a family.
+// Returns 1 on success, 0 if the family is unknown and -1 if the presented id
+// was already used (in which case the family is deleted).
+var rotateFamilyScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return -1
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+type RedisTokenRepository struct {
+	client *redis.Client
+}
+
+func NewRedisTokenRepository(client *redis.Client) *RedisTokenRepository {
+	return &RedisTokenRepository{
+		client: client,
+	}
+}
+
+func familyKey(family string) string {
+	return fmt.Sprintf("refresh_family:%s", family)
+}
+
+func (r *RedisTokenRepository) StartFamily(family, refreshID string, ttl time.Duration) error {
+	ctx := context.Background()
+	return r.client.Set(ctx, familyKey(family), refreshID, ttl).Err()
+}
+
+func (r *RedisTokenRepository) RotateFamily(family, usedID, newID string, ttl time.Duration) error {
+	ctx := context.Background()
+
+	result, err := rotateFamilyScript.Run(ctx, r.client, []string{familyKey(family)}, usedID, newID, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case 0:
+		return token.ErrFamilyNotFound
+	case -1:
+		return token.ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+func (r *RedisTokenRepository) RevokeFamily(family string) error {
+	ctx := context.Background()
+	return r.client.Del(ctx, familyKey(family)).Err()
+}
+
+func (r *RedisTokenRepository) CurrentRefreshID(family string) (string, error) {
+	ctx := context.Background()
+
+	refreshID, err := r.client.Get(ctx, familyKey(family)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return refreshID, err
+}
+
+func denylistKey(jti string) string {
+	return fmt.Sprintf("denylist:%s", jti)
+}
+
+func (r *RedisTokenRepository) Deny(jti string, ttl time.Duration) error {
+	ctx := context.Background()
+	return r.client.Set(ctx, denylistKey(jti), 1, ttl).Err()
+}
+
+func (r *RedisTokenRepository) DenyOnce(jti string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	return r.client.SetNX(ctx, denylistKey(jti), 1, ttl).Result()
+}
+
+func (r *RedisTokenRepository) IsDenied(jti string) (bool, error) {
+	ctx := context.Background()
+
+	count, err := r.client.Exists(ctx, denylistKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func userDeniedKey(userID uint) string {
+	return fmt.Sprintf("denylist_user:%d", userID)
+}
+
+func (r *RedisTokenRepository) DenyUser(userID uint, since time.Time, ttl time.Duration) error {
+	ctx := context.Background()
+	return r.client.Set(ctx, userDeniedKey(userID), since.UnixMicro(), ttl).Err()
+}
+
+func (r *RedisTokenRepository) UserDeniedSince(userID uint) (time.Time, error) {
+	ctx := context.Background()
+
+	value, err := r.client.Get(ctx, userDeniedKey(userID)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	since, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.UnixMicro(since), nil
+}
@@ -2,8 +2,8 @@ package repository
 
 import (
 	"sso/internal/models"
+	"strings"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -11,25 +11,43 @@ type UserRepository interface {
 	CreateUser(email string, password string) (*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(id uint) (*models.User, error)
+	// ListUsers returns a page of users whose email contains query, and the
+	// total number of matches.
+	ListUsers(query string, offset, limit int) ([]models.User, int64, error)
+	SetDisabled(id uint, disabled bool) error
+	SetPasswordResetRequired(id uint, required bool) error
+	SetEmailVerified(id uint, verified bool) error
+	// UpdatePassword stores a new password and clears PasswordResetRequired.
+	UpdatePassword(id uint, password string) error
+	// SetPasswordHash replaces the stored hash, e.g. after it was upgraded
+	// to stronger parameters.
+	SetPasswordHash(id uint, hash string) error
+	DeleteUser(id uint) error
+}
+
+// PasswordHasher hashes passwords before they are stored.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
 }
 
 type GormUserRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	hasher PasswordHasher
 }
 
-func NewUserRepository(db *gorm.DB) *GormUserRepository {
-	return &GormUserRepository{db}
+func NewUserRepository(db *gorm.DB, hasher PasswordHasher) *GormUserRepository {
+	return &GormUserRepository{db, hasher}
 }
 
 func (r *GormUserRepository) CreateUser(email, password string) (*models.User, error) {
-	hashPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashPassword, err := r.hasher.Hash(password)
 	if err != nil {
 		return nil, err
 	}
 
 	user := models.User{
 		Email:    email,
-		Password: string(hashPassword),
+		Password: hashPassword,
 	}
 
 	if result := r.db.Create(&user); result.Error != nil {
@@ -56,3 +74,96 @@ func (r *GormUserRepository) GetUserByID(id uint) (*models.User, error) {
 	}
 	return &user, nil
 }
+
+func (r *GormUserRepository) ListUsers(query string, offset, limit int) ([]models.User, int64, error) {
+	db := r.db.Model(&models.User{})
+	if query != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(query))
+		db = db.Where(`LOWER(email) LIKE ? ESCAPE '\'`, "%"+escaped+"%")
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if err := db.Order("id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (r *GormUserRepository) SetDisabled(id uint, disabled bool) error {
+	return r.updateUser(id, "disabled", disabled)
+}
+
+func (r *GormUserRepository) SetPasswordResetRequired(id uint, required bool) error {
+	return r.updateUser(id, "password_reset_required", required)
+}
+
+func (r *GormUserRepository) SetEmailVerified(id uint, verified bool) error {
+	return r.updateUser(id, "email_verified", verified)
+}
+
+func (r *GormUserRepository) UpdatePassword(id uint, password string) error {
+	hashPassword, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"password":                hashPassword,
+		"password_reset_required": false,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *GormUserRepository) SetPasswordHash(id uint, hash string) error {
+	return r.updateUser(id, "password", hash)
+}
+
+func (r *GormUserRepository) updateUser(id uint, column string, value interface{}) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes the user together with their role grants.
+func (r *GormUserRepository) DeleteUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&models.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sso/internal/models"
+)
+
+type WebAuthnSessionRepository interface {
+	// CreateSession returns an ID identifying the ceremony.
+	CreateSession(session *models.WebAuthnSession, ttl time.Duration) (string, error)
+	// ConsumeSession returns the session and removes it, so every challenge
+	// is answered only once.
+	ConsumeSession(id string) (*models.WebAuthnSession, error)
+}
+
+type RedisWebAuthnSessionRepository struct {
+	client *redis.Client
+}
+
+func NewRedisWebAuthnSessionRepository(client *redis.Client) *RedisWebAuthnSessionRepository {
+	return &RedisWebAuthnSessionRepository{
+		client: client,
+	}
+}
+
+func webAuthnSessionKey(id string) string {
+	return fmt.Sprintf("webauthn_session:%s", hashToken(id))
+}
+
+func (r *RedisWebAuthnSessionRepository) CreateSession(session *models.WebAuthnSession, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	id, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.Set(ctx, webAuthnSessionKey(id), data, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *RedisWebAuthnSessionRepository) ConsumeSession(id string) (*models.WebAuthnSession, error) {
+	ctx := context.Background()
+
+	data, err := r.client.GetDel(ctx, webAuthnSessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.WebAuthnSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
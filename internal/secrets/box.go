@@ -0,0 +1,89 @@
+// Package secrets encrypts values that have to be stored but must not be
+// readable from a database dump, such as TOTP secrets and private keys.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of the AES-256 key.
+const KeySize = 32
+
+// prefix marks sealed values. Values without it were stored before
+// encryption was introduced and are returned as they are.
+const prefix = "enc:v1:"
+
+var ErrCorrupt = errors.New("secrets: value cannot be decrypted")
+
+// Box seals values with AES-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets: key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// DeriveKey turns a passphrase, e.g. the HMAC signing secret, into a key.
+func DeriveKey(passphrase string) []byte {
+	sum := sha256.Sum256([]byte("sso secrets\x00" + passphrase))
+	return sum[:]
+}
+
+// Seal encrypts value. context is authenticated but not stored, typically
+// what the value belongs to, so a sealed value copied to another row does
+// not open.
+func (b *Box) Seal(value, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), []byte(context))
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with the same context.
+func (b *Box) Open(value, context string) (string, error) {
+	if !Sealed(value) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", ErrCorrupt
+	}
+
+	return string(plain), nil
+}
+
+// Sealed reports whether value was encrypted by Seal.
+func Sealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
@@ -1,62 +1,226 @@
 package service
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sso/internal/config"
 	"sso/internal/handlers"
+	"sso/internal/mailer"
 	"sso/internal/middleware"
 	"sso/internal/models"
+	"sso/internal/password"
 	"sso/internal/repository"
+	"sso/internal/secrets"
+	"sso/internal/webauthn"
 	"sso/pkg/token"
+	"syscall"
 	"time"
 
-	gohandlers "github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// mailPollInterval is how often queued mail is retried.
+const mailPollInterval = 30 * time.Second
+
 type SSOService struct {
-	db           *gorm.DB
-	config       config.Config
-	router       *mux.Router
-	userRepo     repository.UserRepository
-	logRepo      repository.LogRepository
-	tokenManager *token.JWTManager
+	db               *gorm.DB
+	config           config.Config
+	router           *mux.Router
+	cors             *middleware.CORSPolicy
+	userRepo         repository.UserRepository
+	logRepo          repository.LogRepository
+	clientRepo       repository.ClientRepository
+	roleRepo         repository.RoleRepository
+	sessionRepo      repository.SessionRepository
+	codeRepo         repository.AuthCodeRepository
+	deviceRepo       repository.DeviceCodeRepository
+	resetRepo        repository.PasswordResetRepository
+	mfaRepo          repository.MFARepository
+	challenges       repository.MFAChallengeRepository
+	webauthnSessions repository.WebAuthnSessionRepository
+	relyingParty     *webauthn.RelyingParty
+	emailLogins      repository.EmailLoginRepository
+	guard            *handlers.LoginGuard
+	rateLimits       repository.RateLimitRepository
+	keyRepo          token.KeyStore
+	tokenManager     *token.JWTManager
+	mailer           mailer.Mailer
+	policy           *password.Policy
+	hasher           *password.Hasher
+	// stop ends the background workers.
+	stop context.CancelFunc
 }
 
 func NewSSOService(cfg config.Config) (*SSOService, error) {
+	if cfg.StepUpACR != "" && !token.ValidACR(cfg.StepUpACR) {
+		err := fmt.Errorf("invalid STEP_UP_ACR %q, use 1, 2 or 3", cfg.StepUpACR)
+		log.Printf("Failed to load config: %v", err)
+		return nil, err
+	}
+
 	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{})
 	if err != nil {
-		log.Printf("Failed to connect to DB: %w", err)
+		log.Printf("Failed to connect to DB: %v", err)
 		return nil, err
 	}
 
-	if err = db.AutoMigrate(&models.User{}); err != nil {
-		log.Printf("Failed to migrate DB: %w", err)
+	// Accounts created before email verification was introduced are trusted.
+	verifyExisting := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "EmailVerified")
+
+	if err = db.AutoMigrate(&models.User{}, &models.SigningKey{}, &models.OAuthClient{},
+		&models.Role{}, &models.RolePermission{}, &models.UserRole{}, &models.OutboxMail{},
+		&models.TOTPCredential{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}); err != nil {
+		log.Printf("Failed to migrate DB: %v", err)
 		return nil, err
 	}
 
-	userRepo := repository.NewUserRepository(db)
+	if verifyExisting {
+		if err := db.Model(&models.User{}).Where("1 = 1").Update("email_verified", true).Error; err != nil {
+			log.Printf("Failed to migrate DB: %v", err)
+			return nil, err
+		}
+	}
+
+	argon2Params := password.DefaultArgon2Params
+	argon2Params.Memory = cfg.Argon2Memory
+	argon2Params.Iterations = cfg.Argon2Iterations
+	argon2Params.Parallelism = cfg.Argon2Parallelism
+
+	hasher, err := password.NewHasher(cfg.PasswordHash, cfg.BcryptCost, argon2Params, cfg.Argon2MemoryLimit)
+	if err != nil {
+		log.Printf("Failed to set up password hashing: %v", err)
+		return nil, err
+	}
+
+	userRepo := repository.NewUserRepository(db, hasher)
+	clientRepo := repository.NewClientRepository(db)
+	roleRepo := repository.NewRoleRepository(db)
+	box, err := newSecretBox(cfg)
+	if err != nil {
+		log.Printf("Failed to set up encryption: %v", err)
+		return nil, err
+	}
+
+	mfaRepo := repository.NewMFARepository(db, box)
+	if err := mfaRepo.SealTOTPSecrets(); err != nil {
+		log.Printf("Failed to encrypt TOTP secrets: %v", err)
+		return nil, err
+	}
+
+	if err := bootstrapRoles(roleRepo, userRepo); err != nil {
+		log.Printf("Failed to create roles: %v", err)
+		return nil, err
+	}
 
-	logRepo, err := repository.NewRedisLogRepository(cfg.RedisURL)
+	redisClient, err := repository.NewRedisClient(cfg.RedisURL)
 	if err != nil {
 		log.Printf("Failed to connect to Redis: %v", err)
 		return nil, err
 	}
 
-	tokenManager := token.NewJWTManager(cfg.JWTSecret, cfg.JWTExpiration)
+	logRepo := repository.NewRedisLogRepository(redisClient)
+	tokenRepo := repository.NewRedisTokenRepository(redisClient)
+	sessionRepo := repository.NewRedisSessionRepository(redisClient)
+	codeRepo := repository.NewRedisAuthCodeRepository(redisClient)
+	deviceRepo := repository.NewRedisDeviceCodeRepository(redisClient)
+	resetRepo := repository.NewRedisPasswordResetRepository(redisClient)
+	rateLimits := repository.NewRedisRateLimitRepository(redisClient)
+	challenges := repository.NewRedisMFAChallengeRepository(redisClient)
+	webauthnSessions := repository.NewRedisWebAuthnSessionRepository(redisClient)
+	emailLogins := repository.NewRedisEmailLoginRepository(redisClient)
+	guard := handlers.NewLoginGuard(repository.NewRedisLockoutRepository(redisClient), handlers.LockoutPolicy{
+		Window:           cfg.LoginFailureWindow,
+		BackoffAfter:     cfg.LoginBackoffAfter,
+		AccountThreshold: cfg.LoginLockoutThreshold,
+		IPThreshold:      cfg.LoginIPLockoutThreshold,
+		LockDuration:     cfg.LoginLockoutDuration,
+	})
+
+	signingKey, err := newSigningKey(cfg)
+	if err != nil {
+		log.Printf("Failed to load signing key: %v", err)
+		return nil, err
+	}
+
+	keyRepo := repository.NewKeyRepository(db, box)
+	if err := keyRepo.SealKeys(); err != nil {
+		log.Printf("Failed to encrypt signing keys: %v", err)
+		return nil, err
+	}
+
+	keyring := token.NewKeyring(keyRepo)
+	tokenManager := token.NewJWTManager(keyring, cfg.Issuer, cfg.JWTExpiration, tokenRepo, tokenRepo, roleRepo)
+	// A replaced key verifies tokens for as long as after an API rotation.
+	if err := keyring.Bootstrap(signingKey, tokenManager.RefreshDuration); err != nil {
+		log.Printf("Failed to load keyring: %v", err)
+		return nil, err
+	}
+	keyring.StartReloading(cfg.KeyReloadInterval)
+
+	policy, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.PasswordClasses, cfg.PasswordBannedFile)
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		return nil, err
+	}
+
+	if cfg.PasswordBreachDataset != "" {
+		breaches, err := password.OpenBreachDataset(cfg.PasswordBreachDataset)
+		if err != nil {
+			log.Printf("Failed to open breach dataset: %v", err)
+			return nil, err
+		}
+		if err := policy.UseBreachDataset(breaches, cfg.PasswordBreachAction); err != nil {
+			log.Printf("Failed to load password policy: %v", err)
+			return nil, err
+		}
+	}
+
+	transport, err := newMailTransport(cfg)
+	if err != nil {
+		log.Printf("Failed to set up mail: %v", err)
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	outbox := mailer.NewOutbox(repository.NewOutboxRepository(db, box), transport)
+	outbox.Start(ctx, mailPollInterval)
 
 	router := mux.NewRouter()
 
 	service := &SSOService{
-		db:           db,
-		config:       cfg,
-		router:       router,
-		userRepo:     userRepo,
-		logRepo:      logRepo,
-		tokenManager: tokenManager,
+		db:               db,
+		config:           cfg,
+		router:           router,
+		cors:             middleware.NewCORSPolicy(clientRepo, cfg.CORSOrigins, time.Minute),
+		userRepo:         userRepo,
+		logRepo:          logRepo,
+		clientRepo:       clientRepo,
+		roleRepo:         roleRepo,
+		sessionRepo:      sessionRepo,
+		codeRepo:         codeRepo,
+		deviceRepo:       deviceRepo,
+		resetRepo:        resetRepo,
+		mfaRepo:          mfaRepo,
+		challenges:       challenges,
+		webauthnSessions: webauthnSessions,
+		relyingParty:     webauthn.NewRelyingParty(cfg.WebAuthnRPID, cfg.WebAuthnRPName, cfg.WebAuthnOrigins),
+		emailLogins:      emailLogins,
+		guard:            guard,
+		rateLimits:       rateLimits,
+		keyRepo:          keyRepo,
+		tokenManager:     tokenManager,
+		mailer:           outbox,
+		policy:           policy,
+		hasher:           hasher,
+		stop:             stop,
 	}
 
 	service.SetupRoutes()
@@ -64,30 +228,215 @@ func NewSSOService(cfg config.Config) (*SSOService, error) {
 	return service, nil
 }
 
+// bootstrapRoles keeps the admin role up to date with all permissions. The
+// "admin" account, which used to be recognised by its email, is granted the
+// role once, while nobody holds it, so revoking it later sticks.
+func bootstrapRoles(roleRepo repository.RoleRepository, userRepo repository.UserRepository) error {
+	err := roleRepo.SaveRole(&models.Role{
+		Name:        models.RoleAdmin,
+		Description: "Full access to the SSO administration",
+		Permissions: models.Permissions,
+	})
+	if err != nil {
+		return err
+	}
+
+	admins, err := roleRepo.CountRoleMembers(models.RoleAdmin)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	if user, err := userRepo.GetUserByEmail("admin"); err == nil {
+		return roleRepo.GrantRole(user.ID, models.RoleAdmin)
+	}
+
+	return nil
+}
+
+func newMailTransport(cfg config.Config) (mailer.Mailer, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:        cfg.SMTPHost,
+			Port:        cfg.SMTPPort,
+			Username:    cfg.SMTPUsername,
+			Password:    cfg.SMTPPassword,
+			From:        cfg.MailFrom,
+			ImplicitTLS: cfg.SMTPImplicitTLS,
+			RequireTLS:  cfg.SMTPRequireTLS,
+		}), nil
+	case "maildir":
+		return mailer.NewMaildirMailer(cfg.MaildirPath, cfg.MailFrom)
+	case "log":
+		return mailer.NewLogMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport: %s", cfg.MailTransport)
+}
+
+func newSecretBox(cfg config.Config) (*secrets.Box, error) {
+	if cfg.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_KEY: %w", err)
+		}
+		return secrets.NewBox(key)
+	}
+
+	if cfg.JWTSecret == "" {
+		return nil, errors.New("ENCRYPTION_KEY or JWT_SECRET must be set")
+	}
+	return secrets.NewBox(secrets.DeriveKey(cfg.JWTSecret))
+}
+
+func budget(name string, key middleware.KeyFunc, limit config.RateLimit) middleware.Budget {
+	return middleware.Budget{
+		Name:   name,
+		Key:    key,
+		Limit:  limit.Limit,
+		Period: limit.Period,
+	}
+}
+
+func newSigningKey(cfg config.Config) (*token.SigningKey, error) {
+	if cfg.JWTSigningAlg == token.AlgHS256 {
+		return token.NewHMACKey(cfg.JWTKeyID, cfg.JWTSecret), nil
+	}
+
+	return token.LoadSigningKey(cfg.JWTSigningAlg, cfg.JWTPrivateKeyPath, cfg.JWTKeyID)
+}
+
 func (s *SSOService) SetupRoutes() {
-	corsHandler := gohandlers.CORS(
-		gohandlers.AllowedOrigins([]string{"*"}),
-		gohandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		gohandlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Accept", "Accept-Language", "Content-Language", "Origin", "Authorization"}),
-		gohandlers.AllowCredentials(),
-	)
+	corsHandler := s.cors.Handler()
+	s.router.Use(middleware.RealIP(s.config.TrustedProxies))
 
-	authHandler := handlers.NewAuthHandler(s.userRepo, s.logRepo, s.tokenManager)
+	authHandler := handlers.NewAuthHandler(s.userRepo, s.logRepo, s.roleRepo, s.tokenManager, s.mailer, s.policy, s.hasher, s.guard, s.mfaRepo, s.challenges, s.webauthnSessions, s.relyingParty, s.emailLogins, s.config.EmailVerification)
 
+	passwordHandler := handlers.NewPasswordHandler(s.userRepo, s.logRepo, s.resetRepo, s.sessionRepo, s.tokenManager, s.mailer, s.policy, s.hasher, s.guard)
+	mfaHandler := handlers.NewMFAHandler(s.userRepo, s.logRepo, s.mfaRepo, s.webauthnSessions, s.relyingParty, s.mailer, s.guard, s.config.TOTPIssuer)
 	profileHandler := handlers.NewProfileHandler(s.userRepo)
-	logHandler := handlers.NewLogHandler(s.userRepo, s.logRepo)
+	stepUp := middleware.StepUp{MaxAge: s.config.StepUpMaxAge, ACR: s.config.StepUpACR}
+	requireStepUp := middleware.RequireStepUp(stepUp)
+	requireRecentLogin := middleware.RequireStepUp(middleware.StepUp{MaxAge: s.config.StepUpMaxAge})
+	logHandler := handlers.NewLogHandler(s.userRepo, s.logRepo, s.roleRepo, stepUp)
+	wellKnownHandler := handlers.NewWellKnownHandler(s.tokenManager)
+	keyHandler := handlers.NewKeyHandler(s.keyRepo, s.tokenManager)
+	clientHandler := handlers.NewClientHandler(s.clientRepo, s.tokenManager)
+	roleHandler := handlers.NewRoleHandler(s.roleRepo, s.userRepo)
+	userHandler := handlers.NewUserHandler(s.userRepo, s.logRepo, s.roleRepo, s.mfaRepo, s.sessionRepo, s.tokenManager, s.guard)
+	oauthHandler := handlers.NewOAuthHandler(s.clientRepo, s.userRepo, s.sessionRepo, s.codeRepo, s.deviceRepo, authHandler, s.tokenManager, s.config.SessionDuration)
 	authMiddleware := middleware.NewAuthMiddleware(s.tokenManager)
+	permissions := middleware.NewPermissionMiddleware(s.roleRepo)
+	limiter := middleware.NewRateLimiter(s.rateLimits)
+
+	limitRegister := limiter.Limit("register",
+		budget("ip", middleware.ByIP, s.config.RateLimitRegisterIP),
+		budget("email", middleware.ByEmail, s.config.RateLimitRegisterEmail),
+	)
+	limitLogin := limiter.Limit("login",
+		budget("ip", middleware.ByIP, s.config.RateLimitLoginIP),
+		budget("email", middleware.ByEmail, s.config.RateLimitLoginEmail),
+	)
+	limitRefresh := limiter.Limit("refresh", budget("ip", middleware.ByIP, s.config.RateLimitRefreshIP))
+	limitVerify := limiter.Limit("verify", budget("ip", middleware.ByIP, s.config.RateLimitVerifyIP))
+	// Clients are counted once they authenticated, see AuthenticateClient.
+	limitToken := limiter.Limit("token", budget("ip", middleware.ByIP, s.config.RateLimitTokenIP))
+	limitTokenClient := limiter.Limit("token", budget("client", middleware.ByClientID, s.config.RateLimitTokenClient))
+	limitMail := limiter.Limit("mail",
+		budget("ip", middleware.ByIP, s.config.RateLimitMailIP),
+		budget("email", middleware.ByEmail, s.config.RateLimitMailEmail),
+	)
+
+	s.router.HandleFunc("/.well-known/jwks.json", wellKnownHandler.JWKS).Methods("GET")
+	s.router.HandleFunc("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration).Methods("GET")
+	s.router.Handle("/userinfo", authMiddleware.Authenticate(middleware.RequireScope("openid")(http.HandlerFunc(profileHandler.UserInfo)))).Methods("GET", "POST")
+
+	s.router.Handle("/api/register", limitRegister(http.HandlerFunc(authHandler.Register))).Methods("POST")
+	s.router.Handle("/api/login", limitLogin(http.HandlerFunc(authHandler.Login))).Methods("POST")
+	s.router.Handle("/api/login/mfa", limitLogin(http.HandlerFunc(authHandler.LoginMFA))).Methods("POST")
+	s.router.Handle("/api/login/mfa/webauthn/options", limitLogin(http.HandlerFunc(authHandler.MFAWebAuthnOptions))).Methods("POST")
+	s.router.Handle("/api/login/webauthn/options", limitLogin(http.HandlerFunc(authHandler.LoginWebAuthnOptions))).Methods("POST")
+	s.router.Handle("/api/login/webauthn", limitLogin(http.HandlerFunc(authHandler.LoginWebAuthn))).Methods("POST")
+	s.router.Handle("/api/login/email", limitMail(http.HandlerFunc(authHandler.LoginEmail))).Methods("POST")
+	s.router.HandleFunc("/api/login/email/verify", oauthHandler.EmailLogin).Methods("GET")
+	s.router.Handle("/api/login/email/verify", limitLogin(http.HandlerFunc(oauthHandler.EmailLogin))).Methods("POST")
+	s.router.Handle("/api/refresh", limitRefresh(http.HandlerFunc(authHandler.RefreshToken))).Methods("POST")
+	s.router.Handle("/api/verify", limitVerify(http.HandlerFunc(authHandler.VerifyToken))).Methods("GET")
+	s.router.HandleFunc("/api/logout", authHandler.Logout).Methods("POST")
+	s.router.HandleFunc("/api/verify-email", authHandler.VerifyEmail).Methods("GET", "POST")
+	s.router.Handle("/api/verify-email/resend", limitMail(http.HandlerFunc(authHandler.ResendVerification))).Methods("POST")
+	s.router.HandleFunc("/api/unlock", authHandler.UnlockAccount).Methods("GET", "POST")
+	s.router.Handle("/api/password/forgot", limitMail(http.HandlerFunc(passwordHandler.ForgotPassword))).Methods("POST")
+	s.router.HandleFunc("/api/password/reset", passwordHandler.ResetPassword).Methods("GET", "POST")
 
-	s.router.HandleFunc("/api/register", authHandler.Register).Methods("POST")
-	s.router.HandleFunc("/api/login", authHandler.Login).Methods("POST")
-	s.router.HandleFunc("/api/refresh", authHandler.RefreshToken).Methods("POST")
-	s.router.HandleFunc("/api/verify", authHandler.VerifyToken).Methods("GET")
+	// Posting the hosted login form signs in like /api/login.
+	s.router.HandleFunc("/authorize", oauthHandler.Authorize).Methods("GET")
+	s.router.Handle("/authorize", limitLogin(http.HandlerFunc(oauthHandler.Authorize))).Methods("POST")
+	s.router.Handle("/token", limitToken(oauthHandler.AuthenticateClient(limitTokenClient(http.HandlerFunc(oauthHandler.Token))))).Methods("POST")
+	s.router.HandleFunc("/oauth/introspect", oauthHandler.Introspect).Methods("POST")
+	s.router.HandleFunc("/oauth/device_authorization", oauthHandler.DeviceAuthorization).Methods("POST")
+	s.router.HandleFunc("/device", oauthHandler.Device).Methods("GET")
+	s.router.Handle("/device", limitLogin(http.HandlerFunc(oauthHandler.Device))).Methods("POST")
+
+	// Logs are also exported by service accounts, register before the user-only routes.
+	logs := s.router.PathPrefix("/api/protected/logs").Subrouter()
+	logs.Use(corsHandler, authMiddleware.AllowClients, middleware.RequireScope("logs:read"))
+	logs.HandleFunc("", logHandler.GetUserLogs).Methods("GET")
 
 	// CORS issue
 	protected := s.router.PathPrefix("/api/protected").Subrouter()
 	protected.Use(corsHandler, authMiddleware.Authenticate)
-	protected.HandleFunc("/profile", profileHandler.GetProfile).Methods("GET")
-	protected.HandleFunc("/logs", logHandler.GetUserLogs).Methods("GET")
+	protected.Handle("/password", requireStepUp(http.HandlerFunc(passwordHandler.ChangePassword))).Methods("POST")
+	protected.HandleFunc("/mfa", mfaHandler.GetStatus).Methods("GET")
+	protected.Handle("/mfa/totp", requireRecentLogin(http.HandlerFunc(mfaHandler.EnrollTOTP))).Methods("POST")
+	protected.Handle("/mfa/totp", requireStepUp(http.HandlerFunc(mfaHandler.DisableTOTP))).Methods("DELETE")
+	protected.HandleFunc("/mfa/totp/confirm", mfaHandler.ConfirmTOTP).Methods("POST")
+	protected.Handle("/mfa/recovery-codes", requireStepUp(http.HandlerFunc(mfaHandler.RegenerateRecoveryCodes))).Methods("POST")
+	protected.Handle("/webauthn/register/options", requireRecentLogin(http.HandlerFunc(mfaHandler.RegisterPasskeyOptions))).Methods("POST")
+	protected.Handle("/webauthn/register", requireRecentLogin(http.HandlerFunc(mfaHandler.RegisterPasskey))).Methods("POST")
+	protected.HandleFunc("/webauthn/credentials", mfaHandler.ListPasskeys).Methods("GET")
+	protected.Handle("/webauthn/credentials/{id:[0-9]+}", requireStepUp(http.HandlerFunc(mfaHandler.DeletePasskey))).Methods("DELETE")
+	protected.Handle("/profile", middleware.RequireScope("profile")(http.HandlerFunc(profileHandler.GetProfile))).Methods("GET")
+
+	admin := s.router.PathPrefix("/api/admin").Subrouter()
+	admin.Use(corsHandler, authMiddleware.Authenticate, middleware.RequireScope("admin"))
+
+	keys := admin.PathPrefix("/keys").Subrouter()
+	keys.Use(permissions.RequirePermission(models.PermKeysManage))
+	keys.HandleFunc("", keyHandler.ListKeys).Methods("GET")
+	keys.HandleFunc("/rotate", keyHandler.RotateKey).Methods("POST")
+
+	clients := admin.PathPrefix("/clients").Subrouter()
+	clients.Use(permissions.RequirePermission(models.PermClientsManage))
+	clients.HandleFunc("", clientHandler.ListClients).Methods("GET")
+	clients.HandleFunc("", clientHandler.CreateClient).Methods("POST")
+	clients.HandleFunc("/{client_id}", clientHandler.GetClient).Methods("GET")
+	clients.HandleFunc("/{client_id}", clientHandler.UpdateClient).Methods("PUT")
+	clients.HandleFunc("/{client_id}", clientHandler.DeleteClient).Methods("DELETE")
+	clients.HandleFunc("/{client_id}/secret", clientHandler.RegenerateSecret).Methods("POST")
+
+	roles := admin.PathPrefix("/roles").Subrouter()
+	roles.Use(permissions.RequirePermission(models.PermRolesManage))
+	roles.HandleFunc("", roleHandler.ListRoles).Methods("GET")
+	roles.HandleFunc("/{name}", roleHandler.SaveRole).Methods("PUT")
+
+	userRoles := admin.PathPrefix("/users/{id:[0-9]+}/roles").Subrouter()
+	userRoles.Use(permissions.RequirePermission(models.PermRolesManage))
+	userRoles.HandleFunc("", roleHandler.GetUserRoles).Methods("GET")
+	userRoles.HandleFunc("", roleHandler.GrantRole).Methods("POST")
+	userRoles.HandleFunc("/{role}", roleHandler.RevokeRole).Methods("DELETE")
+
+	users := admin.PathPrefix("/users").Subrouter()
+	users.Use(permissions.RequirePermission(models.PermUsersManage))
+	users.HandleFunc("", userHandler.ListUsers).Methods("GET")
+	users.HandleFunc("/{id:[0-9]+}", userHandler.GetUser).Methods("GET")
+	users.Handle("/{id:[0-9]+}", requireStepUp(http.HandlerFunc(userHandler.DeleteUser))).Methods("DELETE")
+	users.HandleFunc("/{id:[0-9]+}/disable", userHandler.DisableUser).Methods("POST")
+	users.HandleFunc("/{id:[0-9]+}/enable", userHandler.EnableUser).Methods("POST")
+	users.HandleFunc("/{id:[0-9]+}/unlock", userHandler.UnlockUser).Methods("POST")
+	users.Handle("/{id:[0-9]+}/mfa", requireStepUp(http.HandlerFunc(userHandler.ResetMFA))).Methods("DELETE")
+	users.HandleFunc("/{id:[0-9]+}/password-reset", userHandler.RequirePasswordReset).Methods("POST")
+	users.HandleFunc("/{id:[0-9]+}/sessions", userHandler.RevokeSessions).Methods("DELETE")
 }
 
 func (s *SSOService) Start() error {
@@ -96,12 +445,7 @@ func (s *SSOService) Start() error {
 		port = "8080"
 	}
 
-	corsHandler := gohandlers.CORS(
-		gohandlers.AllowedOrigins([]string{"*"}),
-		gohandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		gohandlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Accept", "Accept-Language", "Content-Language", "Origin", "Authorization"}),
-		gohandlers.AllowCredentials(),
-	)
+	corsHandler := s.cors.Handler()
 
 	srv := &http.Server{
 		Addr:         "localhost:" + port,
@@ -111,7 +455,22 @@ func (s *SSOService) Start() error {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	// On SIGINT or SIGTERM requests in flight are finished and the
+	// background workers stopped.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+		srv.Shutdown(shutdownCtx)
+	}()
+	defer s.stop()
+
 	log.Printf("SSO Service starting on port %s", port)
-	return srv.ListenAndServe()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 	//return http.ListenAndServe("localhost:"+port, corsHandler(s.router))
 }
@@ -0,0 +1,110 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation statement formats.
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+var (
+	ErrAttestation       = errors.New("webauthn: invalid attestation")
+	ErrUnsupportedFormat = errors.New("webauthn: unsupported attestation format")
+)
+
+// id-fido-gen-ce-aaguid, holds the AAGUID of the authenticator model.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAttestation checks the attestation statement
+// (https://www.w3.org/TR/webauthn-2/#sctn-defined-attestation-formats).
+// Certificates are checked for the packed format requirements but not
+// chained to a trust root: the attestation only documents the authenticator
+// model, credentials are trusted because the signed-in user registered them.
+func verifyAttestation(format string, statement map[interface{}]interface{}, authData *AuthenticatorData, rawAuthData, clientDataHash []byte) error {
+	switch format {
+	case FormatNone:
+		if len(statement) != 0 {
+			return ErrAttestation
+		}
+		return nil
+	case FormatPacked:
+		signed := append(append([]byte{}, rawAuthData...), clientDataHash...)
+		return verifyPacked(statement, authData, signed)
+	}
+
+	return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
+func verifyPacked(statement map[interface{}]interface{}, authData *AuthenticatorData, signed []byte) error {
+	alg, _ := statement["alg"].(int64)
+	sig, _ := statement["sig"].([]byte)
+	if sig == nil {
+		return ErrAttestation
+	}
+
+	chain, ok := statement["x5c"].([]interface{})
+	if !ok {
+		// Self attestation, signed with the credential key itself.
+		if _, ok := statement["x5c"]; ok || alg != authData.PublicKey.Alg {
+			return ErrAttestation
+		}
+		if !authData.PublicKey.Verify(signed, sig) {
+			return ErrSignature
+		}
+		return nil
+	}
+
+	if len(chain) == 0 {
+		return ErrAttestation
+	}
+	der, _ := chain[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return ErrAttestation
+	}
+
+	if !verifySignature(alg, cert.PublicKey, signed, sig) {
+		return ErrSignature
+	}
+
+	return verifyPackedCertificate(cert, authData.AAGUID)
+}
+
+// verifyPackedCertificate checks the attestation certificate requirements
+// (https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation-cert-requirements).
+func verifyPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 || cert.IsCA {
+		return ErrAttestation
+	}
+
+	unit := cert.Subject.OrganizationalUnit
+	if len(unit) != 1 || unit[0] != "Authenticator Attestation" {
+		return ErrAttestation
+	}
+	if len(cert.Subject.Country) == 0 || len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return ErrAttestation
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return ErrAttestation
+		}
+
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || !bytes.Equal(value, aaguid) {
+			return ErrAttestation
+		}
+	}
+
+	return nil
+}
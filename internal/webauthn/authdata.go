@@ -0,0 +1,85 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent    = 0x01
+	FlagUserVerified   = 0x04
+	FlagBackupEligible = 0x08
+	FlagBackedUp       = 0x10
+	FlagAttestedData   = 0x40
+	FlagExtensions     = 0x80
+)
+
+var errInvalidAuthData = errors.New("webauthn: invalid authenticator data")
+
+// AuthenticatorData is the data signed by the authenticator, see
+// https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Only present during registration.
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    *PublicKey
+	// RawPublicKey is the COSE encoding of PublicKey.
+	RawPublicKey []byte
+}
+
+func (d *AuthenticatorData) UserPresent() bool  { return d.Flags&FlagUserPresent != 0 }
+func (d *AuthenticatorData) UserVerified() bool { return d.Flags&FlagUserVerified != 0 }
+
+func parseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errInvalidAuthData
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if authData.Flags&FlagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, errInvalidAuthData
+		}
+		authData.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > 1023 || len(rest) < idLength {
+			return nil, errInvalidAuthData
+		}
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		key, after, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		authData.PublicKey = key
+		authData.RawPublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if authData.Flags&FlagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, errInvalidAuthData
+	}
+
+	return authData, nil
+}
@@ -0,0 +1,354 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies
+// (https://www.w3.org/TR/webauthn-2/#sctn-rp-operations).
+//
+// Options and responses use the JSON encoding of PublicKeyCredential.toJSON()
+// and PublicKeyCredential.parse*OptionsFromJSON(), binary fields are base64url.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	challengeSize = 32
+	// Timeout is the time the browser gives the user, challenges should be
+	// kept a little longer.
+	Timeout = 5 * time.Minute
+)
+
+var (
+	ErrInvalidResponse  = errors.New("webauthn: invalid response")
+	ErrChallenge        = errors.New("webauthn: challenge mismatch")
+	ErrOrigin           = errors.New("webauthn: origin not allowed")
+	ErrRPID             = errors.New("webauthn: relying party mismatch")
+	ErrUserPresence     = errors.New("webauthn: user not present")
+	ErrUserVerification = errors.New("webauthn: user not verified")
+	ErrSignature        = errors.New("webauthn: invalid signature")
+	ErrSignCount        = errors.New("webauthn: sign count did not increase")
+)
+
+// Bytes is binary data, encoded as unpadded base64url in JSON.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// RelyingParty holds the identity the credentials are bound to.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+func NewRelyingParty(id, name string, origins []string) *RelyingParty {
+	return &RelyingParty{
+		ID:      id,
+		Name:    name,
+		Origins: origins,
+	}
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() (Bytes, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+type RPEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions is passed to navigator.credentials.create().
+type CreationOptions struct {
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is passed to navigator.credentials.get(). Without
+// AllowCredentials the authenticator offers its discoverable credentials.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a new passkey of the user. Existing credentials
+// are excluded so the same authenticator is not registered twice.
+func (rp *RelyingParty) CreationOptions(challenge Bytes, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]CredentialParameter, len(Algorithms))
+	for i, alg := range Algorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return CreationOptions{
+		RP:                 RPEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "direct",
+	}
+}
+
+// RequestOptions asks for an assertion. userVerification is "required" for
+// passwordless login and "discouraged" when the key is a second factor.
+func (rp *RelyingParty) RequestOptions(challenge Bytes, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// RegistrationResponse is the credential returned by
+// navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes    `json:"clientDataJSON"`
+		AttestationObject Bytes    `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Credential is a verified new credential, ready to be stored.
+type Credential struct {
+	ID                []byte
+	PublicKey         []byte
+	SignCount         uint32
+	AAGUID            []byte
+	Transports        []string
+	AttestationFormat string
+	BackupEligible    bool
+}
+
+// VerifyRegistration checks the response to CreationOptions with the
+// challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(resp *RegistrationResponse, challenge Bytes, requireUserVerification bool) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, ErrInvalidResponse
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+	if authData.PublicKey == nil {
+		return nil, ErrInvalidResponse
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, authData.CredentialID) {
+		return nil, ErrInvalidResponse
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	if err := verifyAttestation(format, statement, authData, rawAuthData, clientDataHash[:]); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:                authData.CredentialID,
+		PublicKey:         authData.RawPublicKey,
+		SignCount:         authData.SignCount,
+		AAGUID:            authData.AAGUID,
+		Transports:        resp.Response.Transports,
+		AttestationFormat: format,
+		BackupEligible:    authData.Flags&FlagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to RequestOptions against the stored
+// public key and sign count and returns the new sign count. A counter that
+// does not increase hints at a cloned authenticator; authenticators that do
+// not count always report zero.
+func (rp *RelyingParty) VerifyAssertion(resp *AssertionResponse, challenge Bytes, publicKey []byte, signCount uint32, requireUserVerification bool) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, ErrInvalidResponse
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.Verify(signed, resp.Response.Signature) {
+		return 0, ErrSignature
+	}
+
+	if (authData.SignCount != 0 || signCount != 0) && authData.SignCount <= signCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.SignCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge Bytes) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ErrInvalidResponse
+	}
+
+	if data.Type != ceremony {
+		return ErrInvalidResponse
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallenge
+	}
+
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrOrigin, data.Origin)
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return ErrRPID
+	}
+
+	if !authData.UserPresent() {
+		return ErrUserPresence
+	}
+
+	if requireUserVerification && !authData.UserVerified() {
+		return ErrUserVerification
+	}
+
+	return nil
+}
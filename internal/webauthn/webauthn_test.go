@@ -0,0 +1,454 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "sso.example.com"
+	testOrigin = "https://sso.example.com"
+)
+
+var testAAGUID = []byte("0123456789abcdef")
+
+func testRelyingParty() *RelyingParty {
+	return NewRelyingParty(testRPID, "SSO", []string{testOrigin})
+}
+
+// cborMap keeps the order of its entries, like authenticators do.
+type cborMap [][2]interface{}
+
+// encodeCBOR encodes the values that occur in attestation objects.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, entry := range v {
+			out = append(out, encodeCBOR(entry[0])...)
+			out = append(out, encodeCBOR(entry[1])...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+// authenticator is a software authenticator with one ES256 credential.
+type authenticator struct {
+	key *ecdsa.PrivateKey
+	id  []byte
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{key: key, id: []byte("credential-1")}
+}
+
+func (a *authenticator) coseKey() []byte {
+	return encodeCBOR(cborMap{
+		{coseKty, ktyEC2},
+		{coseAlg, AlgES256},
+		{coseCrv, crvP256},
+		{coseX, a.key.X.FillBytes(make([]byte, 32))},
+		{coseY, a.key.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+func (a *authenticator) sign(t *testing.T, key *ecdsa.PrivateKey, authData, clientDataJSON []byte) []byte {
+	t.Helper()
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func authenticatorData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+func (a *authenticator) attestedData() []byte {
+	data := append([]byte{}, testAAGUID...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	return append(data, a.coseKey()...)
+}
+
+func clientDataJSON(ceremony string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	return data
+}
+
+// attestationCertificate is issued for the packed format with the subject
+// and AAGUID extension the format requires.
+func attestationCertificate(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aaguid, _ := asn1.Marshal(testAAGUID)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidAAGUID, Value: aaguid}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+// registration describes how the authenticator answers CreationOptions.
+// Zero fields take valid defaults.
+type registration struct {
+	format   string // "none", "packed" (self attestation), "packed-x5c" or a broken variant
+	ceremony string
+	origin   string
+	rpID     string
+	flags    byte
+	// edit changes the encoded attestation object.
+	edit func(attestationObject []byte) []byte
+}
+
+func (reg registration) response(t *testing.T, a *authenticator, challenge []byte) *RegistrationResponse {
+	t.Helper()
+	if reg.ceremony == "" {
+		reg.ceremony = "webauthn.create"
+	}
+	if reg.origin == "" {
+		reg.origin = testOrigin
+	}
+	if reg.rpID == "" {
+		reg.rpID = testRPID
+	}
+	if reg.flags == 0 {
+		reg.flags = FlagUserPresent | FlagUserVerified | FlagAttestedData
+	}
+
+	clientData := clientDataJSON(reg.ceremony, challenge, reg.origin)
+	authData := authenticatorData(reg.rpID, reg.flags, 0, a.attestedData())
+
+	format, statement := FormatNone, cborMap{}
+	switch reg.format {
+	case "packed":
+		format = FormatPacked
+		statement = cborMap{{"alg", AlgES256}, {"sig", a.sign(t, a.key, authData, clientData)}}
+	case "packed-x5c":
+		format = FormatPacked
+		key, der := attestationCertificate(t)
+		statement = cborMap{{"alg", AlgES256}, {"sig", a.sign(t, key, authData, clientData)}, {"x5c", []interface{}{der}}}
+	case "packed-wrong-key":
+		format = FormatPacked
+		other := newAuthenticator(t)
+		statement = cborMap{{"alg", AlgES256}, {"sig", a.sign(t, other.key, authData, clientData)}}
+	case "none-with-statement":
+		statement = cborMap{{"alg", AlgES256}}
+	case "tpm":
+		format = "tpm"
+	}
+
+	attestationObject := encodeCBOR(cborMap{{"fmt", format}, {"attStmt", statement}, {"authData", authData}})
+	if reg.edit != nil {
+		attestationObject = reg.edit(attestationObject)
+	}
+
+	resp := &RegistrationResponse{ID: base64.RawURLEncoding.EncodeToString(a.id), RawID: a.id, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AttestationObject = attestationObject
+	return resp
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t)
+
+	tests := []struct {
+		name      string
+		reg       registration
+		requireUV bool
+		err       error
+	}{
+		{name: "none attestation", reg: registration{format: "none"}},
+		{name: "packed self attestation", reg: registration{format: "packed"}},
+		{name: "packed attestation certificate", reg: registration{format: "packed-x5c"}},
+		{name: "user verification required", reg: registration{flags: FlagUserPresent | FlagAttestedData}, requireUV: true, err: ErrUserVerification},
+		{name: "user not present", reg: registration{flags: FlagUserVerified | FlagAttestedData}, err: ErrUserPresence},
+		{name: "wrong origin", reg: registration{origin: "https://evil.example.com"}, err: ErrOrigin},
+		{name: "origin of a subdomain", reg: registration{origin: "https://login.sso.example.com"}, err: ErrOrigin},
+		{name: "wrong RP ID", reg: registration{rpID: "evil.example.com"}, err: ErrRPID},
+		{name: "assertion ceremony", reg: registration{ceremony: "webauthn.get"}, err: ErrInvalidResponse},
+		{name: "self attestation by another key", reg: registration{format: "packed-wrong-key"}, err: ErrSignature},
+		{name: "none with a statement", reg: registration{format: "none-with-statement"}, err: ErrAttestation},
+		{name: "unsupported format", reg: registration{format: "tpm"}, err: ErrUnsupportedFormat},
+		{name: "no attested credential", reg: registration{flags: FlagUserPresent | FlagUserVerified}, err: errInvalidAuthData},
+		{
+			name: "truncated CBOR",
+			reg:  registration{edit: func(b []byte) []byte { return b[:len(b)-10] }},
+			err:  ErrInvalidResponse,
+		},
+		{
+			name: "trailing bytes after CBOR",
+			reg:  registration{edit: func(b []byte) []byte { return append(b, 0x00) }},
+			err:  ErrInvalidResponse,
+		},
+		{
+			name: "oversized byte string length",
+			reg: registration{edit: func([]byte) []byte {
+				// {"authData": <byte string of 2^40 bytes>} without the bytes.
+				return append([]byte{0xa1, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a'}, 0x5b, 0, 0, 1, 0, 0, 0, 0, 0)
+			}},
+			err: ErrInvalidResponse,
+		},
+		{
+			name: "oversized map length",
+			reg:  registration{edit: func([]byte) []byte { return []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff} }},
+			err:  ErrInvalidResponse,
+		},
+		{
+			name: "truncated authenticator data",
+			reg: registration{edit: func([]byte) []byte {
+				return encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", make([]byte, 36)}})
+			}},
+			err: errInvalidAuthData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, _ := NewChallenge()
+			credential, err := rp.VerifyRegistration(tt.reg.response(t, a, challenge), challenge, tt.requireUV)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyRegistration: %v", err)
+			}
+
+			if string(credential.ID) != string(a.id) || string(credential.AAGUID) != string(testAAGUID) {
+				t.Errorf("credential = %+v", credential)
+			}
+			key, err := ParsePublicKey(credential.PublicKey)
+			if err != nil || !key.Key.(*ecdsa.PublicKey).Equal(&a.key.PublicKey) {
+				t.Errorf("stored public key does not match: %v", err)
+			}
+		})
+	}
+
+	t.Run("other challenge", func(t *testing.T) {
+		challenge, _ := NewChallenge()
+		other, _ := NewChallenge()
+		if _, err := rp.VerifyRegistration(registration{}.response(t, a, other), challenge, false); !errors.Is(err, ErrChallenge) {
+			t.Fatalf("err = %v, want ErrChallenge", err)
+		}
+	})
+}
+
+// assertion describes how the authenticator answers RequestOptions. Zero
+// fields take valid defaults.
+type assertion struct {
+	ceremony  string
+	origin    string
+	rpID      string
+	flags     byte
+	signCount uint32
+	// signer signs instead of the credential key.
+	signer   *ecdsa.PrivateKey
+	authData []byte
+}
+
+func (as assertion) response(t *testing.T, a *authenticator, challenge []byte) *AssertionResponse {
+	t.Helper()
+	if as.ceremony == "" {
+		as.ceremony = "webauthn.get"
+	}
+	if as.origin == "" {
+		as.origin = testOrigin
+	}
+	if as.rpID == "" {
+		as.rpID = testRPID
+	}
+	if as.flags == 0 {
+		as.flags = FlagUserPresent | FlagUserVerified
+	}
+	if as.signer == nil {
+		as.signer = a.key
+	}
+
+	clientData := clientDataJSON(as.ceremony, challenge, as.origin)
+	authData := as.authData
+	if authData == nil {
+		authData = authenticatorData(as.rpID, as.flags, as.signCount, nil)
+	}
+
+	resp := &AssertionResponse{ID: base64.RawURLEncoding.EncodeToString(a.id), RawID: a.id, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = a.sign(t, as.signer, authData, clientData)
+	return resp
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t)
+	other := newAuthenticator(t)
+
+	tests := []struct {
+		name      string
+		as        assertion
+		stored    uint32
+		requireUV bool
+		want      uint32
+		err       error
+	}{
+		{name: "counter increases", as: assertion{signCount: 5}, stored: 4, want: 5},
+		{name: "authenticator without counter", as: assertion{signCount: 0}, stored: 0, want: 0},
+		{name: "counter regression", as: assertion{signCount: 5}, stored: 10, err: ErrSignCount},
+		{name: "counter repeated", as: assertion{signCount: 10}, stored: 10, err: ErrSignCount},
+		{name: "counter reset to zero", as: assertion{signCount: 0}, stored: 10, err: ErrSignCount},
+		{name: "user verification not required", as: assertion{flags: FlagUserPresent, signCount: 1}, want: 1},
+		{name: "user verification required", as: assertion{flags: FlagUserPresent, signCount: 1}, requireUV: true, err: ErrUserVerification},
+		{name: "user not present", as: assertion{flags: FlagUserVerified, signCount: 1}, err: ErrUserPresence},
+		{name: "wrong origin", as: assertion{origin: "https://evil.example.com", signCount: 1}, err: ErrOrigin},
+		{name: "wrong RP ID", as: assertion{rpID: "evil.example.com", signCount: 1}, err: ErrRPID},
+		{name: "registration ceremony", as: assertion{ceremony: "webauthn.create", signCount: 1}, err: ErrInvalidResponse},
+		{name: "signed by another key", as: assertion{signer: other.key, signCount: 1}, err: ErrSignature},
+		{name: "truncated authenticator data", as: assertion{authData: make([]byte, 20)}, err: errInvalidAuthData},
+		{name: "trailing authenticator data", as: assertion{authData: append(authenticatorData(testRPID, FlagUserPresent, 1, nil), 0x00)}, err: errInvalidAuthData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, _ := NewChallenge()
+			signCount, err := rp.VerifyAssertion(tt.as.response(t, a, challenge), challenge, a.coseKey(), tt.stored, tt.requireUV)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyAssertion: %v", err)
+			}
+			if signCount != tt.want {
+				t.Errorf("sign count = %d, want %d", signCount, tt.want)
+			}
+		})
+	}
+
+	t.Run("other challenge", func(t *testing.T) {
+		challenge, _ := NewChallenge()
+		replayed, _ := NewChallenge()
+		_, err := rp.VerifyAssertion(assertion{signCount: 1}.response(t, a, replayed), challenge, a.coseKey(), 0, false)
+		if !errors.Is(err, ErrChallenge) {
+			t.Fatalf("err = %v, want ErrChallenge", err)
+		}
+	})
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	nested := make([]byte, 0, maxCBORDepth+2)
+	for i := 0; i < maxCBORDepth+2; i++ {
+		nested = append(nested, 0x81)
+	}
+	nested = append(nested, 0x00)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{name: "map", data: encodeCBOR(cborMap{{"a", 1}, {-1, []byte{1, 2}}}), valid: true},
+		{name: "empty", data: nil},
+		{name: "truncated byte string", data: []byte{0x45, 1, 2}},
+		{name: "truncated length", data: []byte{0x59, 0x01}},
+		{name: "oversized byte string", data: []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "oversized array", data: []byte{0x9a, 0xff, 0xff, 0xff, 0xff}},
+		{name: "oversized map", data: []byte{0xba, 0x00, 0x01, 0x00, 0x00, 0x01, 0x01}},
+		{name: "truncated map", data: encodeCBOR(cborMap{{"a", 1}})[:2]},
+		{name: "indefinite length", data: []byte{0x5f, 0x41, 0x00, 0xff}},
+		{name: "integer overflow", data: []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "array map key", data: []byte{0xa1, 0x80, 0x00}},
+		{name: "too deeply nested", data: nested},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCBOR(tt.data)
+			if tt.valid && err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, errInvalidCBOR) {
+				t.Fatalf("err = %v, want errInvalidCBOR", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package token
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Authentication method references (RFC 8176) recorded in the "amr" claim.
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+)
+
+// Authentication context classes recorded in the "acr" claim, from the
+// weakest to the strongest.
+const (
+	ACRSingleFactor      = "1"
+	ACRMultiFactor       = "2"
+	ACRPhishingResistant = "3"
+)
+
+var acrLevels = []string{ACRSingleFactor, ACRMultiFactor, ACRPhishingResistant}
+
+// Authentication tells when and how the user signed in. Refreshed tokens
+// keep the original one, only a new sign in makes it recent again.
+type Authentication struct {
+	Time    time.Time
+	Methods []string
+}
+
+// ACR derives the context class from the methods: a hardware key cannot be
+// phished, two factors are stronger than one.
+func (a Authentication) ACR() string {
+	switch {
+	case len(a.Methods) == 0:
+		return ""
+	case hasMethod(a.Methods, AMRHardwareKey):
+		return ACRPhishingResistant
+	case hasMethod(a.Methods, AMRMultiFactor):
+		return ACRMultiFactor
+	}
+	return ACRSingleFactor
+}
+
+func hasMethod(methods []string, want string) bool {
+	for _, method := range methods {
+		if method == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidACR reports whether acr is one of the context classes above.
+func ValidACR(acr string) bool {
+	for _, value := range acrLevels {
+		if value == acr {
+			return true
+		}
+	}
+	return false
+}
+
+// SatisfiesACR reports whether acr is at least as strong as min. Unknown
+// values satisfy nothing.
+func SatisfiesACR(acr, min string) bool {
+	level, minLevel := -1, -1
+	for i, value := range acrLevels {
+		if value == acr {
+			level = i
+		}
+		if value == min {
+			minLevel = i
+		}
+	}
+	return level >= 0 && minLevel >= 0 && level >= minLevel
+}
+
+func (a Authentication) apply(claims jwt.MapClaims) {
+	if a.Time.IsZero() {
+		return
+	}
+
+	claims["auth_time"] = a.Time.Unix()
+	if len(a.Methods) > 0 {
+		claims["amr"] = a.Methods
+		claims["acr"] = a.ACR()
+	}
+}
+
+// AuthenticationFromClaims reads the authentication a token was issued
+// after. Time is zero for tokens that do not carry it.
+func AuthenticationFromClaims(claims jwt.MapClaims) Authentication {
+	var auth Authentication
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		auth.Time = time.Unix(int64(authTime), 0)
+	}
+
+	methods, _ := claims["amr"].([]interface{})
+	for _, method := range methods {
+		if s, ok := method.(string); ok {
+			auth.Methods = append(auth.Methods, s)
+		}
+	}
+
+	return auth
+}
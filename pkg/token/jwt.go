@@ -6,60 +6,185 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 type JWTManager struct {
-	Secret          string
+	Keys            *Keyring
+	Issuer          string
 	TokenDuration   time.Duration
 	RefreshDuration time.Duration
+	families        FamilyStore
+	denylist        Denylist
+	roles           RoleStore
 }
 
-func NewJWTManager(secret string, tokenDuration time.Duration) *JWTManager {
+// RoleStore provides the roles embedded in access tokens.
+type RoleStore interface {
+	GetUserRoles(userID uint) ([]string, error)
+}
+
+func NewJWTManager(keys *Keyring, issuer string, tokenDuration time.Duration, families FamilyStore, denylist Denylist, roles RoleStore) *JWTManager {
 	return &JWTManager{
-		Secret:          secret,
+		Keys:            keys,
+		Issuer:          issuer,
 		TokenDuration:   tokenDuration,
 		RefreshDuration: tokenDuration * 2,
+		families:        families,
+		denylist:        denylist,
+		roles:           roles,
 	}
 }
 
-func (m *JWTManager) Generate(userID uint) (models.TokenResponse, error) {
-	expiresAt := time.Now().Add(m.TokenDuration)
+// Options describe whom a token pair is issued to. ClientID is empty for
+// tokens issued directly by the SSO API.
+type Options struct {
+	UserID    uint
+	ClientID  string
+	Scope     string
+	Auth      Authentication
+	Lifetimes Lifetimes
+}
+
+// Lifetimes override the manager defaults for a client. Zero values keep the
+// defaults.
+type Lifetimes struct {
+	Access  time.Duration
+	Refresh time.Duration
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"type":    "access",
-		"exp":     expiresAt.Unix(),
-	})
+func (m *JWTManager) lifetimes(l Lifetimes) (time.Duration, time.Duration) {
+	access, refresh := m.TokenDuration, m.RefreshDuration
+	if l.Access > 0 {
+		access = l.Access
+	}
+	if l.Refresh > 0 {
+		refresh = l.Refresh
+	}
+	return access, refresh
+}
 
-	tokenString, err := token.SignedString([]byte(m.Secret))
+func optionsFromClaims(claims jwt.MapClaims) Options {
+	opts := Options{}
+	opts.UserID, _ = UserID(claims)
+	opts.ClientID, _ = claims["client_id"].(string)
+	opts.Scope = Scope(claims)
+	opts.Auth = AuthenticationFromClaims(claims)
+	return opts
+}
+
+func (o Options) apply(claims jwt.MapClaims) jwt.MapClaims {
+	claims["user_id"] = o.UserID
+	if o.ClientID != "" {
+		claims["client_id"] = o.ClientID
+	}
+	if o.Scope != "" {
+		claims["scope"] = o.Scope
+	}
+	o.Auth.apply(claims)
+	return claims
+}
+
+// Generate issues a new access/refresh pair after the user signed in as
+// described by auth and starts a new refresh token family.
+func (m *JWTManager) Generate(userID uint, scope string, auth Authentication) (models.TokenResponse, error) {
+	return m.Issue(Options{UserID: userID, Scope: scope, Auth: auth})
+}
+
+// Issue is like Generate but lets the caller bind the tokens to a client and scope.
+func (m *JWTManager) Issue(opts Options) (models.TokenResponse, error) {
+	family := uuid.NewString()
+
+	tokenResp, refreshID, err := m.generate(opts, family)
 	if err != nil {
 		return models.TokenResponse{}, err
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"type":    "access",
-		"exp":     time.Now().Add(m.RefreshDuration).Unix(),
+	_, refreshDuration := m.lifetimes(opts.Lifetimes)
+	if err := m.families.StartFamily(family, refreshID, refreshDuration); err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	return tokenResp, nil
+}
+
+func (m *JWTManager) generate(opts Options, family string) (models.TokenResponse, string, error) {
+	now := time.Now()
+	accessDuration, refreshDuration := m.lifetimes(opts.Lifetimes)
+	expiresAt := now.Add(accessDuration)
+
+	accessClaims := opts.apply(jwt.MapClaims{
+		"type":   "access",
+		"jti":    uuid.NewString(),
+		"iat":    now.Unix(),
+		"iat_us": now.UnixMicro(),
+		"exp":    expiresAt.Unix(),
 	})
 
-	refreshTokenString, err := refreshToken.SignedString([]byte(m.Secret))
+	// Roles are looked up on every issue and refresh, so grants and
+	// revocations show up in the next access token.
+	roles, err := m.roles.GetUserRoles(opts.UserID)
 	if err != nil {
-		return models.TokenResponse{}, err
+		return models.TokenResponse{}, "", err
+	}
+	if len(roles) > 0 {
+		accessClaims["roles"] = roles
+	}
+
+	tokenString, err := m.sign(accessClaims)
+	if err != nil {
+		return models.TokenResponse{}, "", err
+	}
+
+	refreshID := uuid.NewString()
+	refreshTokenString, err := m.sign(opts.apply(jwt.MapClaims{
+		"type":   "refresh",
+		"jti":    refreshID,
+		"fam":    family,
+		"iat":    now.Unix(),
+		"iat_us": now.UnixMicro(),
+		"exp":    now.Add(refreshDuration).Unix(),
+	}))
+	if err != nil {
+		return models.TokenResponse{}, "", err
 	}
 
 	return models.TokenResponse{
 		Token:        tokenString,
 		RefreshToken: refreshTokenString,
 		ExpiresAt:    expiresAt,
-	}, nil
+	}, refreshID, nil
+}
+
+func (m *JWTManager) sign(claims jwt.MapClaims) (string, error) {
+	key := m.Keys.Active()
+	if key == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+
+	if m.Issuer != "" {
+		claims["iss"] = m.Issuer
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
 }
 
 func (m *JWTManager) ValidateToken(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Tokens issued before key ids were introduced carry no "kid".
+		key := m.Keys.Active()
+		if kid, ok := token.Header["kid"].(string); ok {
+			key, ok = m.Keys.Lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key: %v", kid)
+			}
+		}
+		if key == nil || token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(m.Secret), nil
+		return key.public, nil
 	})
 
 	if err != nil {
@@ -71,5 +196,20 @@ func (m *JWTManager) ValidateToken(tokenString string) (*jwt.Token, jwt.MapClaim
 		return nil, nil, fmt.Errorf("invalid token")
 	}
 
+	if err := m.checkRevoked(claims); err != nil {
+		return nil, nil, err
+	}
+
 	return token, claims, nil
 }
+
+// JWKS returns the public keys that relying services use to verify tokens.
+func (m *JWTManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range m.Keys.Keys() {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
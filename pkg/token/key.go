@@ -0,0 +1,267 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+
+	hmacPEMType = "HMAC SECRET"
+)
+
+// SigningKey is a key used to sign tokens, identified by the "kid" header.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+}
+
+func NewHMACKey(id, secret string) *SigningKey {
+	if id == "" {
+		id = "default"
+	}
+
+	return &SigningKey{
+		ID:        id,
+		Algorithm: AlgHS256,
+		method:    jwt.SigningMethodHS256,
+		private:   []byte(secret),
+		public:    []byte(secret),
+	}
+}
+
+// LoadSigningKey reads a PEM encoded private key from path. If id is empty,
+// the RFC 7638 thumbprint of the public key is used as the key id.
+func LoadSigningKey(alg, path, id string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	return ParseSigningKey(alg, data, id)
+}
+
+func ParseSigningKey(alg string, pemData []byte, id string) (*SigningKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in signing key")
+	}
+
+	if block.Type == hmacPEMType {
+		if alg != AlgHS256 {
+			return nil, fmt.Errorf("HMAC secret cannot be used with %s", alg)
+		}
+		return NewHMACKey(id, string(block.Bytes)), nil
+	}
+
+	private, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: id, Algorithm: alg, private: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if alg != AlgRS256 {
+			return nil, fmt.Errorf("RSA key cannot be used with %s", alg)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		if alg != AlgES256 || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA key cannot be used with %s", alg)
+		}
+		key.method = jwt.SigningMethodES256
+		key.public = &k.PublicKey
+	case ed25519.PrivateKey:
+		if alg != AlgEdDSA {
+			return nil, fmt.Errorf("Ed25519 key cannot be used with %s", alg)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.public = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", private)
+	}
+
+	if key.ID == "" {
+		key.ID, err = key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GenerateSigningKey creates a fresh random key for alg.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	if alg == AlgHS256 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKey(uuid.NewString(), string(secret)), nil
+	}
+
+	var private crypto.PrivateKey
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSigningKey(alg, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "")
+}
+
+// MarshalPEM encodes the private key so it can be persisted. HMAC secrets
+// are stored in a dedicated PEM block type.
+func (k *SigningKey) MarshalPEM() ([]byte, error) {
+	if k.Symmetric() {
+		return pem.EncodeToMemory(&pem.Block{Type: hmacPEMType, Bytes: k.private.([]byte)}), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unable to parse signing key")
+}
+
+func (k *SigningKey) Symmetric() bool {
+	return k.Algorithm == AlgHS256
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK returns the public part of the key. Symmetric keys are never published.
+func (k *SigningKey) PublicJWK() (JWK, bool) {
+	enc := base64.RawURLEncoding
+
+	jwk := JWK{Use: "sig", Alg: k.Algorithm, Kid: k.ID}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = enc.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = enc.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// Thumbprint computes the RFC 7638 thumbprint of the public key.
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, ok := k.PublicJWK()
+	if !ok {
+		return "", fmt.Errorf("symmetric keys have no thumbprint")
+	}
+
+	// Required members only, in lexicographic order.
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
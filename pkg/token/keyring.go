@@ -0,0 +1,232 @@
+package token
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sso/internal/models"
+	"sync"
+	"time"
+)
+
+// minLookupReload bounds how often tokens with an unknown key id reload the
+// keyring, so made up ids cannot flood the store.
+const minLookupReload = 10 * time.Second
+
+// KeyStore persists signing keys so that all instances share one keyring.
+type KeyStore interface {
+	// ListKeys returns the active key and retired keys that have not expired yet.
+	ListKeys() ([]models.SigningKey, error)
+	CreateKey(key *models.SigningKey) error
+	// ActivateKey promotes kid to active and retires the previous active key
+	// until retireUntil.
+	ActivateKey(kid string, retireUntil time.Time) error
+	DeleteExpiredKeys() error
+}
+
+// Keyring holds the active signing key and retired keys that are still
+// accepted for verification.
+type Keyring struct {
+	store KeyStore
+
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+
+	lookupMu     sync.Mutex
+	lookupReload time.Time
+}
+
+func NewKeyring(store KeyStore) *Keyring {
+	return &Keyring{
+		store: store,
+		keys:  make(map[string]*SigningKey),
+	}
+}
+
+// Bootstrap makes sure there is an active key, persisting seed if the store
+// is empty. A changed seed replaces the active key if that was seeded too,
+// which stays valid for verification for retention. Keys rotated through the
+// API take precedence over the configuration.
+func (k *Keyring) Bootstrap(seed *SigningKey, retention time.Duration) error {
+	records, err := k.store.ListKeys()
+	if err != nil {
+		return err
+	}
+
+	seedPEM, err := seed.MarshalPEM()
+	if err != nil {
+		return err
+	}
+
+	var active, known *models.SigningKey
+	for i := range records {
+		if records[i].Status == models.KeyStatusActive {
+			active = &records[i]
+		}
+		if records[i].ID == seed.ID {
+			known = &records[i]
+		}
+	}
+
+	switch {
+	case known != nil && !bytes.Equal([]byte(known.PrivateKey), seedPEM):
+		return fmt.Errorf("configured signing key %s differs from the stored key with that id, configure a new key id to replace it", seed.ID)
+	case active != nil && active.ID == seed.ID:
+		return k.Reload()
+	case active != nil && !active.Configured:
+		log.Printf("Configured signing key %s is not used, key %s rotated through the API stays active", seed.ID, active.ID)
+		return k.Reload()
+	}
+
+	if known == nil {
+		if err := k.add(seed, true); err != nil {
+			return err
+		}
+	}
+	if active != nil {
+		log.Printf("Replacing signing key %s with the configured key %s", active.ID, seed.ID)
+	}
+	if err := k.store.ActivateKey(seed.ID, time.Now().Add(retention)); err != nil {
+		return err
+	}
+
+	return k.Reload()
+}
+
+// Reload reads the keyring from the store.
+func (k *Keyring) Reload() error {
+	records, err := k.store.ListKeys()
+	if err != nil {
+		return err
+	}
+
+	var active *SigningKey
+	keys := make(map[string]*SigningKey, len(records))
+	for _, record := range records {
+		key, err := ParseSigningKey(record.Algorithm, []byte(record.PrivateKey), record.ID)
+		if err != nil {
+			return fmt.Errorf("parse signing key %s: %w", record.ID, err)
+		}
+		keys[key.ID] = key
+		if record.Status == models.KeyStatusActive {
+			active = key
+		}
+	}
+
+	k.mu.Lock()
+	k.active = active
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+// StartReloading periodically reloads the keyring so rotations made by other
+// instances are picked up.
+func (k *Keyring) StartReloading(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := k.Reload(); err != nil {
+				log.Printf("Failed to reload keyring: %v", err)
+			}
+		}
+	}()
+}
+
+// Rotate generates a new key, promotes it to active and keeps the previous
+// key valid for verification for retention.
+func (k *Keyring) Rotate(alg string, retention time.Duration) (*SigningKey, error) {
+	if alg == "" {
+		if active := k.Active(); active != nil {
+			alg = active.Algorithm
+		}
+	}
+
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.add(key, false); err != nil {
+		return nil, err
+	}
+	if err := k.store.ActivateKey(key.ID, time.Now().Add(retention)); err != nil {
+		return nil, err
+	}
+	if err := k.store.DeleteExpiredKeys(); err != nil {
+		log.Printf("Failed to delete expired signing keys: %v", err)
+	}
+
+	return key, k.Reload()
+}
+
+func (k *Keyring) add(key *SigningKey, configured bool) error {
+	pemData, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+
+	return k.store.CreateKey(&models.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: string(pemData),
+		Status:     models.KeyStatusPending,
+		Configured: configured,
+	})
+}
+
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Lookup returns the key with the id. An unknown id may belong to a key
+// another instance rotated to since the last reload, so the keyring is
+// reloaded, at most once per minLookupReload.
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	if key, ok := k.lookup(kid); ok {
+		return key, true
+	}
+
+	k.lookupMu.Lock()
+	due := time.Since(k.lookupReload) >= minLookupReload
+	if due {
+		// Claim the reload so concurrent lookups do not repeat it.
+		k.lookupReload = time.Now()
+	}
+	k.lookupMu.Unlock()
+
+	if !due {
+		return nil, false
+	}
+	if err := k.Reload(); err != nil {
+		log.Printf("Failed to reload keyring: %v", err)
+		return nil, false
+	}
+
+	return k.lookup(kid)
+}
+
+func (k *Keyring) lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Keys returns every key accepted for verification.
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,64 @@
+package token
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Purposes of link tokens sent by email.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposeAccountUnlock     = "account_unlock"
+)
+
+// GenerateLinkToken signs a token for a link sent to the user, e.g. to verify
+// their email address. The purpose is stored as the token type, so link
+// tokens are never accepted as access tokens.
+func (m *JWTManager) GenerateLinkToken(purpose string, userID uint, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	return m.sign(jwt.MapClaims{
+		"type":    purpose,
+		"user_id": userID,
+		"email":   email,
+		"jti":     uuid.NewString(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	})
+}
+
+// ConsumeLinkToken validates a link token and revokes it, so every link works
+// only once. Of concurrent requests with the same link only one succeeds.
+func (m *JWTManager) ConsumeLinkToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	_, claims, err := m.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["type"] != purpose {
+		return nil, ErrInvalidTokenType
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, ErrTokenRevoked
+	}
+
+	exp, _ := claims["exp"].(float64)
+	ttl := time.Until(time.Unix(int64(exp), 0))
+	if ttl <= 0 {
+		return nil, ErrTokenRevoked
+	}
+
+	first, err := m.denylist.DenyOnce(jti, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
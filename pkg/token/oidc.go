@@ -0,0 +1,66 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// ErrNoIDTokenKey is returned while the active key is an HMAC secret, which
+// is never published, so relying parties could not verify ID tokens.
+var ErrNoIDTokenKey = errors.New("ID tokens need an asymmetric signing key")
+
+// SignsIDTokens reports whether the active key can sign ID tokens.
+func (m *JWTManager) SignsIDTokens() bool {
+	key := m.Keys.Active()
+	return key != nil && !key.Symmetric()
+}
+
+// GenerateIDToken issues an OpenID Connect ID token for clientID. extra holds
+// profile claims such as email; the standard claims are filled in here.
+func (m *JWTManager) GenerateIDToken(userID uint, clientID, nonce string, auth Authentication, extra map[string]interface{}) (string, error) {
+	if !m.SignsIDTokens() {
+		return "", ErrNoIDTokenKey
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	claims["sub"] = fmt.Sprintf("%d", userID)
+	claims["aud"] = clientID
+	claims["type"] = "id"
+	claims["jti"] = uuid.NewString()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(m.TokenDuration).Unix()
+	auth.apply(claims)
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return m.sign(claims)
+}
+
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge (RFC 7636).
+func VerifyPKCE(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"sso/internal/models"
+)
+
+// memKeyStore keeps signing keys in memory.
+type memKeyStore struct {
+	keys []models.SigningKey
+}
+
+func (s *memKeyStore) ListKeys() ([]models.SigningKey, error) {
+	return append([]models.SigningKey(nil), s.keys...), nil
+}
+
+func (s *memKeyStore) CreateKey(key *models.SigningKey) error {
+	s.keys = append(s.keys, *key)
+	return nil
+}
+
+func (s *memKeyStore) ActivateKey(kid string, retireUntil time.Time) error {
+	for i := range s.keys {
+		switch {
+		case s.keys[i].ID == kid:
+			s.keys[i].Status = models.KeyStatusActive
+		case s.keys[i].Status == models.KeyStatusActive:
+			s.keys[i].Status = models.KeyStatusRetired
+			s.keys[i].ExpiresAt = &retireUntil
+		}
+	}
+	return nil
+}
+
+func (s *memKeyStore) DeleteExpiredKeys() error {
+	return nil
+}
+
+// defaultManager is set up like the service without JWT_SIGNING_ALG.
+func defaultManager(t *testing.T) *JWTManager {
+	t.Helper()
+
+	keys := NewKeyring(&memKeyStore{})
+	if err := keys.Bootstrap(NewHMACKey("", "secret"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return NewJWTManager(keys, "https://sso.example.com", time.Hour, nil, nil, nil)
+}
+
+func TestGenerateIDTokenDefaultConfig(t *testing.T) {
+	m := defaultManager(t)
+
+	if m.SignsIDTokens() {
+		t.Error("SignsIDTokens with an HMAC key")
+	}
+	if _, err := m.GenerateIDToken(1, "app", "", Authentication{}, nil); !errors.Is(err, ErrNoIDTokenKey) {
+		t.Errorf("GenerateIDToken with an HMAC key: %v, want ErrNoIDTokenKey", err)
+	}
+	if keys := m.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("JWKS publishes %d keys, want none", len(keys))
+	}
+}
+
+func TestGenerateIDTokenAsymmetricKey(t *testing.T) {
+	m := defaultManager(t)
+	if _, err := m.Keys.Rotate(AlgES256, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.SignsIDTokens() {
+		t.Error("SignsIDTokens is false with an ES256 key")
+	}
+	idToken, err := m.GenerateIDToken(1, "app", "nonce", Authentication{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		key, ok := m.Keys.Lookup(t.Header["kid"].(string))
+		if !ok || key.Symmetric() {
+			return nil, errors.New("unknown key")
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "1" || claims["aud"] != "app" || claims["nonce"] != "nonce" {
+		t.Errorf("claims = %v", claims)
+	}
+	if keys := m.JWKS().Keys; len(keys) != 1 || keys[0].Alg != AlgES256 {
+		t.Errorf("JWKS = %+v, want the ES256 key", keys)
+	}
+}
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"errors"
+	"sso/internal/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// exchanged is presented again. The whole token family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrFamilyNotFound is returned when the token family was revoked or expired.
+	ErrFamilyNotFound   = errors.New("refresh token family not found")
+	ErrInvalidTokenType = errors.New("invalid token type")
+	ErrClientMismatch   = errors.New("token was issued to another client")
+)
+
+// FamilyStore keeps the latest refresh token id of every token family, so each
+// refresh token can be exchanged exactly once.
+type FamilyStore interface {
+	StartFamily(family, refreshID string, ttl time.Duration) error
+	// RotateFamily replaces usedID with newID. If usedID is not the latest
+	// token of the family, the family is revoked and ErrRefreshTokenReused
+	// is returned.
+	RotateFamily(family, usedID, newID string, ttl time.Duration) error
+	RevokeFamily(family string) error
+	// CurrentRefreshID returns the latest token id of the family, or an empty
+	// string if the family was revoked or expired.
+	CurrentRefreshID(family string) (string, error)
+}
+
+// Refresh exchanges a refresh token issued to clientID for a new token pair in
+// the same family. The options are returned even on reuse so the caller can
+// record the event.
+func (m *JWTManager) Refresh(refreshToken, clientID string, lifetimes Lifetimes) (models.TokenResponse, Options, error) {
+	_, claims, err := m.ValidateToken(refreshToken)
+	if err != nil {
+		return models.TokenResponse{}, Options{}, err
+	}
+
+	if claims["type"] != "refresh" {
+		return models.TokenResponse{}, Options{}, ErrInvalidTokenType
+	}
+
+	opts := optionsFromClaims(claims)
+	opts.Lifetimes = lifetimes
+	if opts.ClientID != clientID {
+		return models.TokenResponse{}, opts, ErrClientMismatch
+	}
+
+	family, _ := claims["fam"].(string)
+	refreshID, _ := claims["jti"].(string)
+	if family == "" || refreshID == "" {
+		return models.TokenResponse{}, opts, ErrFamilyNotFound
+	}
+
+	tokenResp, newRefreshID, err := m.generate(opts, family)
+	if err != nil {
+		return models.TokenResponse{}, opts, err
+	}
+
+	_, refreshDuration := m.lifetimes(lifetimes)
+	if err := m.families.RotateFamily(family, refreshID, newRefreshID, refreshDuration); err != nil {
+		return models.TokenResponse{}, opts, err
+	}
+
+	return tokenResp, opts, nil
+}
+
+// Introspect validates an access or refresh token and reports whether it is
+// still usable. Refresh tokens that were already exchanged are not active.
+func (m *JWTManager) Introspect(tokenString string) (jwt.MapClaims, error) {
+	_, claims, err := m.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	switch claims["type"] {
+	case "access":
+		return claims, nil
+	case "refresh":
+		family, _ := claims["fam"].(string)
+		refreshID, _ := claims["jti"].(string)
+
+		current, err := m.families.CurrentRefreshID(family)
+		if err != nil {
+			return nil, err
+		}
+		if current == "" || current != refreshID {
+			return nil, ErrFamilyNotFound
+		}
+
+		return claims, nil
+	}
+
+	return nil, ErrInvalidTokenType
+}
@@ -0,0 +1,91 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// Denylist holds ids of revoked tokens until they would have expired anyway.
+type Denylist interface {
+	Deny(jti string, ttl time.Duration) error
+	// DenyOnce is like Deny but reports false if the token was denied already.
+	DenyOnce(jti string, ttl time.Duration) (bool, error)
+	IsDenied(jti string) (bool, error)
+	// DenyUser rejects all tokens of the user issued before since.
+	DenyUser(userID uint, since time.Time, ttl time.Duration) error
+	// UserDeniedSince returns the zero time if the user has no revoked tokens.
+	UserDeniedSince(userID uint) (time.Time, error)
+}
+
+// Revoke puts the token on the denylist. Revoking a refresh token also
+// revokes its whole family.
+func (m *JWTManager) Revoke(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil
+	}
+
+	exp, _ := claims["exp"].(float64)
+	ttl := time.Until(time.Unix(int64(exp), 0))
+	if ttl > 0 {
+		if err := m.denylist.Deny(jti, ttl); err != nil {
+			return err
+		}
+	}
+
+	if family, ok := claims["fam"].(string); ok && family != "" {
+		return m.families.RevokeFamily(family)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes every token issued to the user so far, e.g. when the
+// account is disabled. Tokens issued afterwards are not affected.
+func (m *JWTManager) RevokeUser(userID uint) error {
+	since := time.Now().Truncate(time.Microsecond)
+	return m.denylist.DenyUser(userID, since, m.RefreshDuration)
+}
+
+// issuedBefore reports whether the token was issued before t. "iat" only has
+// a resolution of one second, so tokens carry the issue time in microseconds
+// as well; tokens without it are compared conservatively by the second.
+func issuedBefore(claims jwt.MapClaims, t time.Time) bool {
+	if iat, ok := claims["iat_us"].(float64); ok {
+		return int64(iat) < t.UnixMicro()
+	}
+
+	iat, _ := claims["iat"].(float64)
+	return int64(iat) <= t.Unix()
+}
+
+func (m *JWTManager) checkRevoked(claims jwt.MapClaims) error {
+	// Tokens issued before ids were introduced carry no "jti", but are
+	// still revoked along with their user.
+	if jti, _ := claims["jti"].(string); jti != "" {
+		denied, err := m.denylist.IsDenied(jti)
+		if err != nil {
+			return err
+		}
+		if denied {
+			return ErrTokenRevoked
+		}
+	}
+
+	if userID, ok := UserID(claims); ok {
+		since, err := m.denylist.UserDeniedSince(userID)
+		if err != nil {
+			return err
+		}
+
+		if !since.IsZero() && issuedBefore(claims, since) {
+			return ErrTokenRevoked
+		}
+	}
+
+	return nil
+}
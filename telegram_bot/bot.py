@@ -1,12 +1,13 @@
+import asyncio
 import logging
 import os
 import json
+import sqlite3
 import requests
 from datetime import datetime
 from dotenv import load_dotenv
 from aiogram import Bot, Dispatcher, types, F
 from aiogram.fsm.context import FSMContext
-from aiogram.fsm.state import State, StatesGroup
 from aiogram.filters import Command
 from aiogram.types import InlineKeyboardButton, InlineKeyboardMarkup
 from aiogram.methods.send_message import SendMessage
@@ -22,13 +23,54 @@ logger = logging.getLogger(__name__)
 
 TOKEN = os.getenv("TELEGRAM_BOT_TOKEN")
 SSO_SERVICE_URL = os.getenv("SSO_SERVICE_URL", "http://localhost:8080")
+# Публичный клиент SSO с грантом device_code (и refresh_token)
+SSO_CLIENT_ID = os.getenv("SSO_CLIENT_ID", "telegram-bot")
+TOKENS_DB = os.getenv("TOKENS_DB", "tokens.db")
 
-# переделать на БД, не хранить в питоновском словаре
-user_tokens = {}
+DEVICE_CODE_GRANT = "urn:ietf:params:oauth:grant-type:device_code"
 
-class AuthStates(StatesGroup):
-    waiting_for_login = State()
-    waiting_for_password = State()
+db = sqlite3.connect(TOKENS_DB)
+db.execute(
+    "CREATE TABLE IF NOT EXISTS tokens ("
+    "telegram_id INTEGER PRIMARY KEY, access_token TEXT NOT NULL, refresh_token TEXT)"
+)
+db.commit()
+
+def save_tokens(telegram_id, token_data):
+    db.execute(
+        "INSERT OR REPLACE INTO tokens (telegram_id, access_token, refresh_token) VALUES (?, ?, ?)",
+        (telegram_id, token_data["access_token"], token_data.get("refresh_token"))
+    )
+    db.commit()
+
+def load_tokens(telegram_id):
+    return db.execute(
+        "SELECT access_token, refresh_token FROM tokens WHERE telegram_id = ?",
+        (telegram_id,)
+    ).fetchone()
+
+def delete_tokens(telegram_id):
+    db.execute("DELETE FROM tokens WHERE telegram_id = ?", (telegram_id,))
+    db.commit()
+
+def refresh_tokens(telegram_id, refresh_token):
+    if not refresh_token:
+        return None
+
+    response = requests.post(
+        f"{SSO_SERVICE_URL}/token",
+        data={
+            "grant_type": "refresh_token",
+            "refresh_token": refresh_token,
+            "client_id": SSO_CLIENT_ID,
+        }
+    )
+    if response.status_code != 200:
+        return None
+
+    token_data = response.json()
+    save_tokens(telegram_id, token_data)
+    return token_data["access_token"]
 
 bot = Bot(token=TOKEN)
 dp = Dispatcher()
@@ -62,11 +104,13 @@ def format_logs_html(logs):
         
         user = log.get("email", "Неизвестный пользователь")
         success = log.get("success", False)
+        event = log.get("event", "login")
         ip = log.get("ip", "")
         user_agent = log.get("user_agent", "")
         
         formatted_logs += f"🕒 <b>{timestamp}</b>\n"
         formatted_logs += f"👤 Пользователь: <code>{user}</code>\n"
+        formatted_logs += f"🔸 Событие: <code>{event}</code>\n"
         
         if success:
             formatted_logs += f"✅ Статус: <code>Успешно</code>\n"
@@ -95,60 +139,112 @@ async def cmd_start(message: types.Message):
     )
 
 @dp.callback_query(F.data == "login")
-async def login_callback(callback: types.CallbackQuery, state: FSMContext):
+async def login_callback(callback: types.CallbackQuery):
     await callback.answer()
-    await callback.message.edit_text("Введите логин:")
-    await state.set_state(AuthStates.waiting_for_login)
-
-@dp.message(AuthStates.waiting_for_login)
-async def process_login(message: types.Message, state: FSMContext):
-    await state.update_data(login=message.text)
-    await message.answer("Пароль:")
-    await state.set_state(AuthStates.waiting_for_password)
-
-@dp.message(AuthStates.waiting_for_password)
-async def process_password(message: types.Message, state: FSMContext):
-    user_data = await state.get_data()
-    login = user_data.get('login', '')
-    password = message.text
-    
+
     try:
-        response = requests.post(
-            f"{SSO_SERVICE_URL}/api/login",
-            json={"email": login, "password": password}
+        response = await asyncio.to_thread(
+            requests.post,
+            f"{SSO_SERVICE_URL}/oauth/device_authorization",
+            data={"client_id": SSO_CLIENT_ID, "scope": "email logs:read"}
         )
-        
+    except Exception as e:
+        await callback.message.answer(f"Произошла ошибка при авторизации: {str(e)}")
+        return
+
+    if response.status_code != 200:
+        await callback.message.answer(f"Ошибка авторизации: {response.text}")
+        return
+
+    device = response.json()
+    await callback.message.edit_text(
+        "Откройте ссылку и подтвердите вход в SSO:\n"
+        f"{device['verification_uri_complete']}\n\n"
+        f"Код: <code>{device['user_code']}</code>",
+        parse_mode="HTML"
+    )
+
+    asyncio.create_task(poll_device_token(callback.message, callback.from_user.id, device))
+
+async def poll_device_token(message: types.Message, telegram_id, device):
+    interval = device.get("interval", 5)
+    deadline = asyncio.get_running_loop().time() + device.get("expires_in", 600)
+
+    while asyncio.get_running_loop().time() < deadline:
+        await asyncio.sleep(interval)
+
+        try:
+            response = await asyncio.to_thread(
+                requests.post,
+                f"{SSO_SERVICE_URL}/token",
+                data={
+                    "grant_type": DEVICE_CODE_GRANT,
+                    "device_code": device["device_code"],
+                    "client_id": SSO_CLIENT_ID,
+                }
+            )
+        except Exception as e:
+            logger.error(f"Ошибка опроса SSO: {str(e)}")
+            continue
+
         if response.status_code == 200:
-            token_data = response.json()
-            token = token_data.get("token", "")
-            user_tokens[message.from_user.id] = token
-            
+            save_tokens(telegram_id, response.json())
             await message.answer("Успех. Теперь можно получить логи")
+            break
+
+        error = response.json().get("error", "")
+        if error == "authorization_pending":
+            continue
+        if error == "slow_down":
+            interval += 5
+            continue
+
+        if error == "access_denied":
+            await message.answer("Вход отклонён.")
+        elif error == "expired_token":
+            await message.answer("Код истёк, попробуйте войти снова.")
         else:
             await message.answer(f"Ошибка авторизации: {response.text}")
-    except Exception as e:
-        await message.answer(f"Произошла ошибка при авторизации: {str(e)}")
-    
+        break
+    else:
+        await message.answer("Код истёк, попробуйте войти снова.")
+
     await message.answer("Выберите действие:", reply_markup=get_main_keyboard())
-    await state.clear()
 
 @dp.callback_query(F.data == "get_logs")
 async def get_logs_callback(callback: types.CallbackQuery):
     await callback.answer()
     
     user_id = callback.from_user.id
-    if user_id not in user_tokens:
+    tokens = load_tokens(user_id)
+    if tokens is None:
         await callback.message.edit_text(
             "Вы не авторизованы. Используйте команду /start для начала."
         )
         return
     
-    token = user_tokens[user_id]
+    token, refresh_token = tokens
     try:
         response = requests.get(
             f"{SSO_SERVICE_URL}/api/protected/logs",
             headers={"Authorization": f"Bearer {token}"}
         )
+
+        # Токен истёк или отозван, пробуем обновить
+        if response.status_code == 401:
+            token = refresh_tokens(user_id, refresh_token)
+            if token is None:
+                delete_tokens(user_id)
+                await callback.message.answer(
+                    "Сессия истекла. Войдите снова.",
+                    reply_markup=get_main_keyboard()
+                )
+                return
+
+            response = requests.get(
+                f"{SSO_SERVICE_URL}/api/protected/logs",
+                headers={"Authorization": f"Bearer {token}"}
+            )
         
         if response.status_code == 200:
             logs = response.json()
@@ -210,5 +306,4 @@ async def main():
     await dp.start_polling(bot)
 
 if __name__ == "__main__":
-    import asyncio
     asyncio.run(main())
\ No newline at end of file